@@ -0,0 +1,309 @@
+package blocklist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+List is a set of blocked IP ranges loaded from a file in any of these formats
+(mixed freely, one entry per line):
+
+- eMule ipfilter.dat: "001.002.003.000 - 001.002.003.255 , 000 , Description",
+  entries with an access level above 127 are allowed and skipped
+- PeerGuardian P2P text: "Description:1.2.3.0-1.2.3.255"
+- plain CIDR or single addresses: "1.2.3.0/24", "2001:db8::/32", "1.2.3.4"
+
+Blank lines and lines starting with '#' or "//" are ignored, and so are lines
+that cannot be parsed (they are only counted). The ranges are kept sorted and
+merged so a lookup is a binary search. IPv4-mapped IPv6 addresses are treated
+as the IPv4 address.
+*/
+
+const RELOAD_INTERVAL = time.Minute // how often Watch checks the file for changes
+const MAX_ALLOWED_LEVEL = 127       // ipfilter.dat entries with a higher access level are not blocked
+
+type Range struct {
+	From netip.Addr
+	To   netip.Addr
+}
+
+func (r Range) contains(ip netip.Addr) bool {
+	return r.From.Compare(ip) <= 0 && ip.Compare(r.To) <= 0
+}
+
+type List struct {
+	mu      sync.RWMutex
+	ranges  []Range
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// parseAddr parses an address, accepting the zero padded octets of ipfilter.dat
+func parseAddr(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	if strings.Count(s, ".") == 3 && !strings.Contains(s, ":") {
+		octets := strings.Split(s, ".")
+		var b [4]byte
+		for i, octet := range octets {
+			n, err := strconv.ParseUint(octet, 10, 8)
+			if err != nil {
+				return netip.Addr{}, fmt.Errorf("invalid IPv4 address %q", s)
+			}
+			b[i] = byte(n)
+		}
+		return netip.AddrFrom4(b), nil
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return ip.Unmap(), nil
+}
+
+func parseRange(from string, to string) (Range, error) {
+	start, err := parseAddr(from)
+	if err != nil {
+		return Range{}, err
+	}
+	end, err := parseAddr(to)
+	if err != nil {
+		return Range{}, err
+	}
+	if start.Is4() != end.Is4() || end.Less(start) {
+		return Range{}, fmt.Errorf("invalid range %s-%s", from, to)
+	}
+	return Range{From: start, To: end}, nil
+}
+
+// lastAddr is the highest address of a prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	ip, _ := netip.AddrFromSlice(b)
+	return ip
+}
+
+// parseLine parses one entry, ok is false for lines without an entry
+func parseLine(line string) (r Range, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+		return Range{}, false, nil
+	}
+
+	// P2P: description:from-to, the description may contain colons and commas itself
+	if i := strings.LastIndex(line, ":"); i >= 0 {
+		if from, to, found := strings.Cut(line[i+1:], "-"); found {
+			if r, err := parseRange(from, to); err == nil {
+				return r, true, nil
+			}
+		}
+	}
+
+	// ipfilter.dat: range , level , description
+	if fields := strings.Split(line, ","); len(fields) >= 2 {
+		level, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+		if err != nil {
+			return Range{}, false, fmt.Errorf("invalid access level %q", fields[1])
+		}
+		if level > MAX_ALLOWED_LEVEL {
+			return Range{}, false, nil
+		}
+		from, to, found := strings.Cut(fields[0], "-")
+		if !found {
+			return Range{}, false, fmt.Errorf("invalid range %q", fields[0])
+		}
+		r, err := parseRange(from, to)
+		return r, err == nil, err
+	}
+
+	if strings.Contains(line, "/") {
+		prefix, err := netip.ParsePrefix(line)
+		if err != nil {
+			return Range{}, false, err
+		}
+		prefix = prefix.Masked()
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		return Range{From: prefix.Addr(), To: lastAddr(prefix)}, true, nil
+	}
+
+	if from, to, found := strings.Cut(line, "-"); found {
+		r, err := parseRange(from, to)
+		return r, err == nil, err
+	}
+	ip, err := parseAddr(line)
+	return Range{From: ip, To: ip}, err == nil, err
+}
+
+// merge sorts the ranges and joins the overlapping and adjacent ones
+func merge(ranges []Range) []Range {
+	slices.SortFunc(ranges, func(a, b Range) int { return a.From.Compare(b.From) })
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			next := last.To.Next()
+			if last.From.Is4() == r.From.Is4() && (r.From.Compare(last.To) <= 0 || (next.IsValid() && r.From == next)) {
+				if last.To.Less(r.To) {
+					last.To = r.To
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// Parse reads a blocklist, invalid is the number of lines that were skipped because they could not be parsed
+func Parse(r io.Reader) (ranges []Range, invalid int, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		entry, ok, err := parseLine(scanner.Text())
+		if err != nil {
+			invalid++
+			continue
+		}
+		if ok {
+			ranges = append(ranges, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, invalid, err
+	}
+	return merge(ranges), invalid, nil
+}
+
+func New(ranges []Range) *List {
+	return &List{ranges: merge(slices.Clone(ranges))}
+}
+
+// Load reads the blocklist at path, Watch keeps it up to date afterwards
+func Load(path string) (*List, error) {
+	l := &List{path: path}
+	if _, err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload reads the file again if it changed since the last load and reports whether it did
+func (l *List) Reload() (bool, error) {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return false, err
+	}
+	l.mu.RLock()
+	unchanged := info.ModTime().Equal(l.modTime) && info.Size() == l.size
+	l.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	ranges, invalid, err := Parse(file)
+	if err != nil {
+		return false, fmt.Errorf("failed to read blocklist %s: %w", l.path, err)
+	}
+	if invalid > 0 {
+		fmt.Fprintf(os.Stderr, "Blocklist %s: skipped %d invalid lines\n", l.path, invalid)
+	}
+
+	l.mu.Lock()
+	l.ranges = ranges
+	l.modTime = info.ModTime()
+	l.size = info.Size()
+	l.mu.Unlock()
+	return true, nil
+}
+
+// Watch reloads the file every RELOAD_INTERVAL when it changed, until stop is closed; onReload (may be nil) runs after each reload
+func (l *List) Watch(stop <-chan struct{}, onReload func()) {
+	ticker := time.NewTicker(RELOAD_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := l.Reload()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to reload blocklist:", err)
+			} else if reloaded {
+				fmt.Printf("Reloaded blocklist %s: %d ranges\n", l.path, l.Len())
+				if onReload != nil {
+					onReload()
+				}
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Len returns the number of (merged) ranges
+func (l *List) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.ranges)
+}
+
+func (l *List) Blocked(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	return l.BlockedAddr(addr)
+}
+
+func (l *List) BlockedAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	// the last range starting at or before ip is the only one that can contain it
+	i, found := slices.BinarySearchFunc(l.ranges, ip, func(r Range, ip netip.Addr) int { return r.From.Compare(ip) })
+	if found {
+		return true
+	}
+	return i > 0 && l.ranges[i-1].contains(ip)
+}
+
+// Listener drops inbound connections from blocked addresses
+type Listener struct {
+	net.Listener
+	list *List
+}
+
+func (l *List) Listener(inner net.Listener) *Listener {
+	return &Listener{Listener: inner, list: l}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && l.list.Blocked(addr.IP) {
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+}
@@ -0,0 +1,130 @@
+package blocklist
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		from, to string // empty when the line has no entry
+		invalid  bool
+	}{
+		{line: "001.002.003.000 - 001.002.003.255 , 000 , Some ISP", from: "1.2.3.0", to: "1.2.3.255"},
+		{line: "010.000.000.000 - 010.255.255.255 , 127 , at the limit", from: "10.0.0.0", to: "10.255.255.255"},
+		{line: "010.000.000.000 - 010.255.255.255 , 128 , allowed"},
+		{line: "001.002.003.000 - 001.002.003.255 , high , x", invalid: true},
+		{line: "Bad people:5.6.7.0-5.6.7.255", from: "5.6.7.0", to: "5.6.7.255"},
+		{line: "Name: with, commas and : colons:5.6.7.8-5.6.7.9", from: "5.6.7.8", to: "5.6.7.9"},
+		{line: "1.2.3.0/24", from: "1.2.3.0", to: "1.2.3.255"},
+		{line: "1.2.3.77/24", from: "1.2.3.0", to: "1.2.3.255"},
+		{line: "2001:db8::/32", from: "2001:db8::", to: "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+		{line: "::ffff:1.2.3.0/120", from: "1.2.3.0", to: "1.2.3.255"},
+		{line: "9.9.9.9", from: "9.9.9.9", to: "9.9.9.9"},
+		{line: "9.9.9.9 - 9.9.9.1", invalid: true},
+		{line: "1.2.3.4 - 2001:db8::1", invalid: true},
+		{line: "# comment"},
+		{line: "// comment"},
+		{line: "   "},
+		{line: "not an address", invalid: true},
+	}
+	for _, tt := range tests {
+		r, ok, err := parseLine(tt.line)
+		if (err != nil) != tt.invalid {
+			t.Errorf("%q: error %v, want invalid %v", tt.line, err, tt.invalid)
+			continue
+		}
+		if tt.from == "" {
+			if ok {
+				t.Errorf("%q: got range %v, want none", tt.line, r)
+			}
+			continue
+		}
+		if !ok || r.From.String() != tt.from || r.To.String() != tt.to {
+			t.Errorf("%q: got %v-%v (ok %v), want %s-%s", tt.line, r.From, r.To, ok, tt.from, tt.to)
+		}
+	}
+}
+
+func TestParseMergesRanges(t *testing.T) {
+	input := strings.Join([]string{
+		"1.0.0.0-1.0.0.9",
+		"a:1.0.0.5-1.0.0.20", // overlaps
+		"001.000.000.021 - 001.000.000.030 , 0 , adjacent",
+		"1.0.0.40/32",
+		"2001:db8::/48",
+		"bogus line",
+	}, "\n")
+	ranges, invalid, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invalid != 1 {
+		t.Errorf("%d invalid lines, want 1", invalid)
+	}
+	want := []string{"1.0.0.0-1.0.0.30", "1.0.0.40-1.0.0.40", "2001:db8::-2001:db8:0:ffff:ffff:ffff:ffff:ffff"}
+	if len(ranges) != len(want) {
+		t.Fatalf("got %v, want %v", ranges, want)
+	}
+	for i, r := range ranges {
+		if got := r.From.String() + "-" + r.To.String(); got != want[i] {
+			t.Errorf("range %d is %s, want %s", i, got, want[i])
+		}
+	}
+}
+
+func TestBlocked(t *testing.T) {
+	l := New([]Range{
+		{netip.MustParseAddr("1.2.3.0"), netip.MustParseAddr("1.2.3.255")},
+		{netip.MustParseAddr("2001:db8::"), netip.MustParseAddr("2001:db8::ff")},
+	})
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"1.2.3.0", true},
+		{"1.2.3.128", true},
+		{"1.2.4.0", false},
+		{"1.2.2.255", false},
+		{"::ffff:1.2.3.4", true},
+		{"2001:db8::10", true},
+		{"2001:db8::100", false},
+	}
+	for _, tt := range tests {
+		if got := l.Blocked(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("%s: blocked %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.p2p")
+	if err := os.WriteFile(path, []byte("a:1.2.3.0-1.2.3.255\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Blocked(net.ParseIP("1.2.3.4")) {
+		t.Error("1.2.3.4 is not blocked")
+	}
+	if reloaded, err := l.Reload(); reloaded || err != nil {
+		t.Errorf("unchanged file reloaded %v (%v)", reloaded, err)
+	}
+
+	if err := os.WriteFile(path, []byte("a:5.6.7.0-5.6.7.255\nb:8.8.8.8-8.8.8.8\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := l.Reload(); !reloaded || err != nil {
+		t.Fatalf("changed file reloaded %v (%v)", reloaded, err)
+	}
+	if l.Blocked(net.ParseIP("1.2.3.4")) || !l.Blocked(net.ParseIP("5.6.7.8")) || l.Len() != 2 {
+		t.Errorf("the reload did not replace the ranges, %d ranges", l.Len())
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"torrent-client/src/ratelimit"
+)
+
+/*
+control => commands typed on standard input while a torrent runs, one per line
+
+	move [dir]                    moves the data and the resume data to dir,
+	                              the download goes on from the new location
+	limit [level] [dir] [KiB/s]   changes a rate limit right away, level is
+	                              global, torrent or peer, dir is down or up
+	                              and 0 means unlimited. The global limit set
+	                              here is the one used outside the alternate
+	                              schedule.
+
+Without a terminal (stdin closed or /dev/null) there are no commands.
+*/
+func readControl(in io.Reader, move func(dir string) error, limit func(level string, dir string, rate int64) error) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		command, arg, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		arg = strings.TrimSpace(arg)
+		switch command {
+		case "":
+		case "move":
+			if arg == "" {
+				fmt.Fprintln(os.Stderr, "Usage: move [dir]")
+				continue
+			}
+			if err := move(arg); err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to move the download:", err)
+			}
+		case "limit":
+			level, dir, rate, err := parseLimit(arg)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				continue
+			}
+			if err := limit(level, dir, rate); err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to change the limit:", err)
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown command %q, known: move [dir], limit [level] [dir] [KiB/s]\n", command)
+		}
+	}
+}
+
+// parseLimit reads the arguments of a limit command, the rate is returned in bytes per second
+func parseLimit(arg string) (string, string, int64, error) {
+	fields := strings.Fields(arg)
+	if len(fields) != 3 {
+		return "", "", 0, errors.New("Usage: limit [global|torrent|peer] [down|up] [KiB/s]")
+	}
+	level, dir := fields[0], fields[1]
+	if level != "global" && level != "torrent" && level != "peer" {
+		return "", "", 0, fmt.Errorf("unknown level %q, expected global, torrent or peer", level)
+	}
+	if dir != "down" && dir != "up" {
+		return "", "", 0, fmt.Errorf("unknown direction %q, expected down or up", dir)
+	}
+	kib, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || kib < 0 {
+		return "", "", 0, fmt.Errorf("invalid rate %q, expected KiB/s", fields[2])
+	}
+	return level, dir, kib * 1024, nil
+}
+
+// setLimit changes one rate limit of the global scheduler or of the torrent's limits
+func setLimit(scheduler *ratelimit.Scheduler, limits *ratelimit.TorrentLimits, level string, dir string, rate int64) error {
+	switch level {
+	case "global":
+		down, up := scheduler.Normal()
+		if dir == "down" {
+			down = rate
+		} else {
+			up = rate
+		}
+		scheduler.SetNormal(down, up)
+	case "torrent":
+		if dir == "down" {
+			limits.Down.SetRate(rate)
+		} else {
+			limits.Up.SetRate(rate)
+		}
+	case "peer":
+		if dir == "down" {
+			limits.PeerDown.Store(rate)
+		} else {
+			limits.PeerUp.Store(rate)
+		}
+	default:
+		return fmt.Errorf("unknown level %q", level)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"torrent-client/src/ratelimit"
+)
+
+func TestLimitCommand(t *testing.T) {
+	scheduler := ratelimit.NewScheduler(ratelimit.NewLimits(0, 0), 0, 0, nil)
+	limits := ratelimit.NewTorrentLimits(0, 0, 0, 0)
+	limit := func(level string, dir string, rate int64) error {
+		return setLimit(scheduler, limits, level, dir, rate)
+	}
+	readControl(strings.NewReader("limit global down 100\nlimit torrent up 50\nlimit peer down 10\nlimit peer sideways 1\nlimit torrent down -1\n"), nil, limit)
+
+	if down, up := scheduler.Normal(); down != 100*1024 || up != 0 {
+		t.Errorf("global limits %d/%d", down, up)
+	}
+	if limits.Up.Rate() != 50*1024 || limits.Down.Rate() != 0 {
+		t.Errorf("torrent limits %d/%d", limits.Down.Rate(), limits.Up.Rate())
+	}
+	if limits.PeerDown.Load() != 10*1024 || limits.PeerUp.Load() != 0 {
+		t.Errorf("peer limits %d/%d", limits.PeerDown.Load(), limits.PeerUp.Load())
+	}
+}
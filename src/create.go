@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"torrent-client/src/encoder"
+)
+
+/*
+create => ./torrent-client create [options] [path]
+Creates a torrent of a file or directory without asking anything, for
+scripts. Each -tracker is one tier, the urls of a tier separated by commas.
+Without a path the details are asked for on stdin instead.
+*/
+
+// listFlag collects the values of a flag given several times
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func createCommand(args []string) {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	var trackers, webSeeds listFlag
+	fs.Var(&trackers, "tracker", "tier of announce urls separated by commas, repeat for more tiers")
+	fs.Var(&webSeeds, "webseed", "web seed url (url-list), can be repeated")
+	comment := fs.String("comment", "", "comment")
+	private := fs.Bool("private", false, "mark the torrent private, peers only come from its trackers")
+	source := fs.String("source", "", "source tag stored in the info dictionary")
+	pieceLength := fs.Uint64("piece-length", 0, "piece length in KiB, a power of two; picked from the size when 0")
+	name := fs.String("name", "", "name of the torrent, the base name of the path by default")
+	output := fs.String("o", "", "torrent file to write, or a directory for <name>.torrent; the current directory by default")
+	createdBy := fs.String("created-by", "torrent-client", "created by")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ./torrent-client create [options] [path]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var path string
+	var err error
+	switch fs.NArg() {
+	case 0:
+		path, err = encoder.Interactive()
+	case 1:
+		opts := encoder.Options{
+			Path:        fs.Arg(0),
+			Name:        *name,
+			WebSeeds:    webSeeds,
+			Comment:     *comment,
+			CreatedBy:   *createdBy,
+			Private:     *private,
+			Source:      *source,
+			PieceLength: *pieceLength * 1024,
+			Output:      *output,
+		}
+		for _, tier := range trackers {
+			opts.Trackers = append(opts.Trackers, strings.Split(tier, ","))
+		}
+		path, err = encoder.Create(opts)
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to create torrent:", err)
+		os.Exit(1)
+	}
+
+	t := readTorrent(path)
+	fmt.Printf("Torrent created at: %s\nInfo hash: %x, %d pieces of %d KiB\n", path, t.InfoHash, t.Info.PieceCount, t.Info.PieceLength/1024)
+}
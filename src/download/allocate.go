@@ -0,0 +1,109 @@
+package download
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type AllocationMode int
+
+const (
+	ALLOCATE_SPARSE AllocationMode = iota // files are extended to their length without using disk space
+	ALLOCATE_FULL                         // disk space is reserved for the whole file up front
+)
+
+func ParseAllocationMode(mode string) (AllocationMode, error) {
+	switch mode {
+	case "sparse":
+		return ALLOCATE_SPARSE, nil
+	case "full":
+		return ALLOCATE_FULL, nil
+	default:
+		return 0, fmt.Errorf("unknown allocation mode %s, expected sparse or full", mode)
+	}
+}
+
+/*
+Allocate creates every file of the torrent at its final size. Data already
+in the files is kept, so allocating a partially downloaded torrent is safe.
+*/
+func (s *Storage) Allocate(mode AllocationMode) error {
+	s.io.RLock()
+	defer s.io.RUnlock()
+
+	for _, lf := range s.files {
+		h, err := s.open(lf.Path, true)
+		if err != nil {
+			return err
+		}
+		err = allocate(h.f, int64(lf.Length), mode)
+		s.release(h)
+		if err != nil {
+			return fmt.Errorf("failed to allocate %s: %w", lf.Path, err)
+		}
+	}
+	return nil
+}
+
+func allocate(f *os.File, length int64, mode AllocationMode) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	switch mode {
+	case ALLOCATE_FULL:
+		return preallocate(f, info.Size(), length)
+	default:
+		if info.Size() < length {
+			return f.Truncate(length)
+		}
+	}
+	return nil
+}
+
+// writeZeros fills the file with zeros from size up to length, which allocates the blocks on any filesystem
+func writeZeros(f *os.File, size int64, length int64) error {
+	zeros := make([]byte, 1024*1024)
+	for size < length {
+		n := min(int64(len(zeros)), length-size)
+		if _, err := f.WriteAt(zeros[:n], size); err != nil {
+			return err
+		}
+		size += n
+	}
+	return nil
+}
+
+// CheckFreeSpace refuses a download that does not fit on the disk holding the storage
+func (s *Storage) CheckFreeSpace() error {
+	baseDir := s.BaseDir()
+
+	// the blocks the files already have do not need more space; a sparse file has its full size but few blocks
+	var needed uint64
+	for _, lf := range s.files {
+		needed += lf.Length
+		if info, err := os.Stat(filepath.Join(baseDir, lf.Path)); err == nil {
+			needed -= min(allocatedSize(info), lf.Length)
+		}
+	}
+
+	// the base directory may not exist yet, check the closest parent that does
+	dir := baseDir
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	free, err := freeSpace(dir)
+	if err != nil {
+		// not knowing the free space is no reason to refuse the download
+		return nil
+	}
+	if free < needed {
+		return fmt.Errorf("not enough space in %s: %d bytes needed, %d available", dir, needed, free)
+	}
+	return nil
+}
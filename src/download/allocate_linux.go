@@ -0,0 +1,20 @@
+package download
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func preallocate(f *os.File, size int64, length int64) error {
+	if size >= length {
+		return nil
+	}
+	// mode 0 reserves the blocks and extends the file, existing data is untouched
+	err := syscall.Fallocate(int(f.Fd()), 0, size, length-size)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.ENOSYS) {
+		// the filesystem (some NFS and FUSE mounts) cannot reserve blocks, writing them works everywhere
+		return writeZeros(f, size, length)
+	}
+	return err
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package download
+
+import (
+	"os"
+)
+
+// preallocate writes zeros from the end of the file up to its length where fallocate is not available
+func preallocate(f *os.File, size int64, length int64) error {
+	return writeZeros(f, size, length)
+}
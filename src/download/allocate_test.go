@@ -0,0 +1,93 @@
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"torrent-client/src/parser"
+)
+
+const allocLength = 4 * 1024 * 1024
+
+// allocTorrent is a torrent of two files with a few bytes of the first one already on disk
+func allocTorrent(t *testing.T) (*Storage, []LayoutFile) {
+	t.Helper()
+	torrent := seedTorrent("alloc", []parser.InfoFile{
+		{Length: allocLength, Path: []string{"a"}},
+		{Length: allocLength / 2, Path: []string{"sub", "b"}},
+	}, make([]byte, allocLength+allocLength/2), 1024*1024)
+	storage := NewStorage(torrent, t.TempDir())
+	t.Cleanup(func() { storage.Close() })
+	if err := storage.WriteAt([]byte("kept"), 0); err != nil {
+		t.Fatal(err)
+	}
+	return storage, Layout(torrent)
+}
+
+func TestAllocate(t *testing.T) {
+	for _, mode := range []AllocationMode{ALLOCATE_SPARSE, ALLOCATE_FULL} {
+		storage, files := allocTorrent(t)
+		if err := storage.Allocate(mode); err != nil {
+			t.Fatalf("mode %d: %v", mode, err)
+		}
+		storage.Close()
+
+		for _, lf := range files {
+			info, err := os.Stat(filepath.Join(storage.BaseDir(), lf.Path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != int64(lf.Length) {
+				t.Errorf("mode %d: %s has %d bytes, want %d", mode, lf.Path, info.Size(), lf.Length)
+			}
+			allocated := allocatedSize(info)
+			if mode == ALLOCATE_FULL && allocated < lf.Length {
+				t.Errorf("full: %s has %d bytes allocated, want %d", lf.Path, allocated, lf.Length)
+			}
+			if mode == ALLOCATE_SPARSE && allocated >= lf.Length {
+				t.Logf("sparse: %s is fully allocated, the filesystem has no sparse files", lf.Path)
+			}
+		}
+
+		data, err := os.ReadFile(filepath.Join(storage.BaseDir(), "a"))
+		if err != nil || string(data[:4]) != "kept" {
+			t.Errorf("mode %d: the data already written was lost", mode)
+		}
+	}
+}
+
+func TestWriteZeros(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.WriteString("abc")
+	if err := writeZeros(f, 3, 3*1024*1024+5); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := f.Stat()
+	if info.Size() != 3*1024*1024+5 {
+		t.Errorf("size %d", info.Size())
+	}
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := freeSpace(dir); err != nil {
+		t.Skip("free space unknown here:", err)
+	}
+
+	small := seedTorrent("small", []parser.InfoFile{{Length: 1024}}, make([]byte, 1024), 1024)
+	if err := NewStorage(small, filepath.Join(dir, "not", "yet")).CheckFreeSpace(); err != nil {
+		t.Errorf("a 1 KiB torrent does not fit: %v", err)
+	}
+
+	huge := &parser.Torrent{TotalLength: 1 << 60}
+	huge.Info.Name = "huge"
+	huge.Info.PieceLength = 1 << 30
+	huge.Info.PieceCount = 1 << 30
+	if err := NewStorage(huge, dir).CheckFreeSpace(); err == nil {
+		t.Error("an exabyte torrent fits")
+	}
+}
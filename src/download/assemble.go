@@ -13,9 +13,12 @@ type FileReader struct {
 	Pos      uint32
 }
 
+func PieceFileName(pieceIndex uint32) string {
+	return fmt.Sprintf("piece%d.part", pieceIndex)
+}
+
 func WritePiece(pieceIndex uint32, piece []byte, outDir string) error {
-	fileName := fmt.Sprintf("piece%d.part", pieceIndex)
-	fullPath := filepath.Join(outDir, fileName)
+	fullPath := filepath.Join(outDir, PieceFileName(pieceIndex))
 
 	// fmt.Println(fullPath)
 
@@ -32,6 +35,33 @@ func WritePiece(pieceIndex uint32, piece []byte, outDir string) error {
 	return nil
 }
 
+/*
+ImportPieceFiles moves pieces that older versions left in piece files into
+the storage. A piece file name that is also the name of one of the torrent's
+files is that file, it is left alone.
+*/
+func ImportPieceFiles(t *parser.Torrent, storage *Storage) error {
+	for i := range t.Info.PieceCount {
+		name := PieceFileName(i)
+		if isTorrentFile(storage.files, name) {
+			continue
+		}
+		path := filepath.Join(storage.BaseDir(), name)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to read a file[%s]: %w", path, err)
+		}
+
+		if err := storage.WriteAt(data, uint64(i)*t.Info.PieceLength); err != nil {
+			return err
+		}
+		os.Remove(path)
+	}
+	return nil
+}
+
 func singleFileWrite(outDir string, fileName string) error {
 	fullPath := filepath.Join(outDir, fileName)
 	if _, err := os.Stat(outDir); os.IsNotExist(err) {
@@ -130,7 +160,7 @@ func AssembleFiles(t *parser.Torrent, outDir string, deletePieces bool) error {
 		return fmt.Errorf("torrent is nil")
 	}
 
-	baseDir := filepath.Join(outDir, t.Info.Name)
+	baseDir := BaseDir(t, outDir)
 	if _, err := os.Stat(baseDir); os.IsNotExist(err) {
 		return fmt.Errorf("output directory %s does not exist", baseDir)
 	}
@@ -140,7 +170,7 @@ func AssembleFiles(t *parser.Torrent, outDir string, deletePieces bool) error {
 	// pieceLen := int(t.Info.PieceLength)
 
 	piecePath := func(index int) string {
-		return filepath.Join(baseDir, fmt.Sprintf("piece%d.part", index))
+		return filepath.Join(baseDir, PieceFileName(uint32(index)))
 	}
 
 	readPiece := func(index int) ([]byte, error) {
@@ -185,7 +215,7 @@ func AssembleFiles(t *parser.Torrent, outDir string, deletePieces bool) error {
 
 	if !t.HasMultipleFiles {
 		// Single-file torrent
-		outputFile := filepath.Join(baseDir, t.Info.Name)
+		outputFile := filepath.Join(baseDir, SafeName(t))
 		out, err := os.Create(outputFile)
 		if err != nil {
 			return fmt.Errorf("failed to create output file: %w", err)
@@ -204,8 +234,9 @@ func AssembleFiles(t *parser.Torrent, outDir string, deletePieces bool) error {
 		}
 	} else {
 		// Multi-file torrent
-		for _, f := range t.Info.Files {
-			filePath := filepath.Join(baseDir, filepath.Join(f.Path...))
+		paths := SafePaths(t.Info.Files)
+		for i, f := range t.Info.Files {
+			filePath := filepath.Join(baseDir, paths[i])
 			if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 				return fmt.Errorf("failed to create directory for %s: %w", filePath, err)
 			}
@@ -0,0 +1,50 @@
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"torrent-client/src/parser"
+)
+
+func TestImportPieceFilesSkipsTorrentFiles(t *testing.T) {
+	data := []byte("ownfilelegacy...")
+	torrent := seedTorrent("dir", []parser.InfoFile{
+		{Length: 8, Path: []string{"piece0.part"}},
+		{Length: 8, Path: []string{"b"}},
+	}, data, 8)
+	storage := NewStorage(torrent, t.TempDir())
+	defer storage.Close()
+
+	baseDir := storage.BaseDir()
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	own := filepath.Join(baseDir, "piece0.part")
+	legacy := filepath.Join(baseDir, "piece1.part")
+	if err := os.WriteFile(own, data[:8], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(legacy, data[8:], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ImportPieceFiles(torrent, storage); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(own); err != nil || string(got) != string(data[:8]) {
+		t.Errorf("the torrent's own piece0.part became %q (%v)", got, err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("the legacy piece file was not removed: %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(baseDir, "b")); err != nil || string(got) != string(data[8:]) {
+		t.Errorf("b is %q (%v), want the legacy piece", got, err)
+	}
+
+	// the recheck reads piece 0 from the torrent's file too
+	piece, err := ReadPiece(torrent, Layout(torrent), baseDir, 0)
+	if err != nil || string(piece) != string(data[:8]) {
+		t.Errorf("piece 0 read as %q (%v)", piece, err)
+	}
+}
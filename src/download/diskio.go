@@ -0,0 +1,325 @@
+package download
+
+import (
+	"container/list"
+	"fmt"
+	"slices"
+	"sync"
+	"torrent-client/src/parser"
+)
+
+/*
+DiskIO takes verified pieces off the peer goroutines and writes them to the
+Storage on its own goroutine.
+
+- written pieces stay in a write-back cache until the writer gets to them,
+  pieces at consecutive indices are written with a single call when the
+  buffer joining them fits the budget, one call per piece otherwise
+- reads (for uploads) are served from the cache when possible, a miss also
+  reads ahead the following pieces we have
+- dirty and cached pieces and the writer's buffer together never take more
+  than the memory budget, writers block while the budget is used up by dirty
+  pieces
+*/
+
+const DISK_CACHE_SIZE = 64 * 1024 * 1024 // 64 MiB
+const READ_AHEAD = 2                     // pieces read ahead on a cache miss
+
+type DiskStats struct {
+	QueueDepth    int // pieces waiting to be written
+	DirtyBytes    int64
+	CachedBytes   int64
+	CacheHits     uint64
+	CacheMisses   uint64
+	WriteCalls    uint64
+	PiecesWritten uint64
+}
+
+type dirtyPiece struct {
+	index uint32
+	data  []byte
+	done  func(error)
+}
+
+type cachedPiece struct {
+	index uint32
+	data  []byte
+}
+
+type DiskIO struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	t       *parser.Torrent
+	storage *Storage
+	budget  int64
+
+	pending  []dirtyPiece
+	writing  map[uint32][]byte // pieces taken off pending by the writer but not written yet
+	clean    *list.List        // LRU of cachedPiece, most recently used in front
+	cleanIdx map[uint32]*list.Element
+	joining  int64 // bytes of the buffer the writer joins a run of pieces in
+	closed   bool
+	stats    DiskStats
+}
+
+func NewDiskIO(t *parser.Torrent, storage *Storage, budget int64) *DiskIO {
+	d := &DiskIO{
+		t:        t,
+		storage:  storage,
+		budget:   budget,
+		writing:  make(map[uint32][]byte),
+		clean:    list.New(),
+		cleanIdx: make(map[uint32]*list.Element),
+	}
+	d.cond = sync.NewCond(&d.mu)
+	go d.writer()
+	return d
+}
+
+func (d *DiskIO) Storage() *Storage {
+	return d.storage
+}
+
+/*
+Write queues a verified piece. done is called from the writer goroutine once
+the piece is on disk (or failed to get there). Write blocks while dirty
+pieces use up the whole memory budget.
+*/
+func (d *DiskIO) Write(pieceIndex uint32, piece []byte, done func(error)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	size := int64(len(piece))
+	for d.stats.DirtyBytes > 0 && d.stats.DirtyBytes+d.joining+size > d.budget {
+		d.cond.Wait()
+	}
+	d.evict(size)
+
+	d.pending = append(d.pending, dirtyPiece{index: pieceIndex, data: piece, done: done})
+	d.stats.DirtyBytes += size
+	d.stats.QueueDepth = len(d.pending) + len(d.writing)
+	d.cond.Broadcast()
+}
+
+/*
+Read returns length bytes from begin within a piece, for serving a block to a
+peer. have is our bitfield, only pieces in it are read ahead.
+*/
+func (d *DiskIO) Read(pieceIndex uint32, begin uint32, length uint32, have []byte) ([]byte, error) {
+	// the range comes from a peer's request
+	if pieceIndex >= d.t.Info.PieceCount {
+		return nil, fmt.Errorf("piece %d out of range", pieceIndex)
+	}
+	if uint64(begin)+uint64(length) > PieceLength(d.t, pieceIndex) {
+		return nil, fmt.Errorf("block %d+%d past the end of piece %d", begin, length, pieceIndex)
+	}
+
+	d.mu.Lock()
+	if piece := d.lookup(pieceIndex); piece != nil {
+		d.stats.CacheHits++
+		d.mu.Unlock()
+		return slices.Clone(piece[begin : begin+length]), nil
+	}
+	d.stats.CacheMisses++
+	d.mu.Unlock()
+
+	var block []byte
+	last := min(pieceIndex+READ_AHEAD, d.t.Info.PieceCount-1)
+	for i := pieceIndex; i <= last; i++ {
+		if i != pieceIndex && !hasPiece(have, i) {
+			break
+		}
+		piece := make([]byte, PieceLength(d.t, i))
+		err := d.storage.ReadAt(piece, uint64(i)*d.t.Info.PieceLength)
+		if err != nil {
+			if i == pieceIndex {
+				return nil, err
+			}
+			break
+		}
+		if i == pieceIndex {
+			block = slices.Clone(piece[begin : begin+length])
+		}
+
+		d.mu.Lock()
+		if d.lookup(i) == nil {
+			d.cache(i, piece)
+		}
+		d.mu.Unlock()
+	}
+	return block, nil
+}
+
+// lookup finds a piece in the dirty or clean cache, the caller holds d.mu
+func (d *DiskIO) lookup(pieceIndex uint32) []byte {
+	for _, p := range d.pending {
+		if p.index == pieceIndex {
+			return p.data
+		}
+	}
+	if data, ok := d.writing[pieceIndex]; ok {
+		return data
+	}
+	if el, ok := d.cleanIdx[pieceIndex]; ok {
+		d.clean.MoveToFront(el)
+		return el.Value.(cachedPiece).data
+	}
+	return nil
+}
+
+func hasPiece(bitfield []byte, pieceIndex uint32) bool {
+	return int(pieceIndex/8) < len(bitfield) && bitfield[pieceIndex/8]&(1<<(7-pieceIndex%8)) != 0
+}
+
+// cache adds a piece to the clean cache, replacing what it had for the piece, the caller holds d.mu
+func (d *DiskIO) cache(pieceIndex uint32, data []byte) {
+	if el, ok := d.cleanIdx[pieceIndex]; ok {
+		d.clean.Remove(el)
+		delete(d.cleanIdx, pieceIndex)
+		d.stats.CachedBytes -= int64(len(el.Value.(cachedPiece).data))
+	}
+	size := int64(len(data))
+	d.evict(size)
+	if d.used()+size > d.budget {
+		return
+	}
+	d.cleanIdx[pieceIndex] = d.clean.PushFront(cachedPiece{index: pieceIndex, data: data})
+	d.stats.CachedBytes += size
+}
+
+// used is the memory the budget is used by, the caller holds d.mu
+func (d *DiskIO) used() int64 {
+	return d.stats.DirtyBytes + d.stats.CachedBytes + d.joining
+}
+
+// evict drops least recently used clean pieces until size more bytes fit the budget
+func (d *DiskIO) evict(size int64) {
+	for d.clean.Len() > 0 && d.used()+size > d.budget {
+		el := d.clean.Back()
+		p := d.clean.Remove(el).(cachedPiece)
+		delete(d.cleanIdx, p.index)
+		d.stats.CachedBytes -= int64(len(p.data))
+	}
+}
+
+func (d *DiskIO) writer() {
+	for {
+		d.mu.Lock()
+		for len(d.pending) == 0 && !d.closed {
+			d.cond.Wait()
+		}
+		if d.closed && len(d.pending) == 0 {
+			d.mu.Unlock()
+			return
+		}
+		batch := d.pending
+		d.pending = nil
+		for _, p := range batch {
+			d.writing[p.index] = p.data
+		}
+		d.mu.Unlock()
+
+		slices.SortFunc(batch, func(a, b dirtyPiece) int { return int(a.index) - int(b.index) })
+
+		// coalesce runs of consecutive pieces into one write
+		for start := 0; start < len(batch); {
+			end := start + 1
+			for end < len(batch) && batch[end].index == batch[end-1].index+1 {
+				end++
+			}
+			run := batch[start:end]
+			calls, err := d.writeRun(run)
+
+			d.mu.Lock()
+			for _, p := range run {
+				delete(d.writing, p.index)
+				d.stats.DirtyBytes -= int64(len(p.data))
+				if err == nil {
+					d.cache(p.index, p.data)
+				}
+			}
+			d.stats.WriteCalls += calls
+			d.stats.PiecesWritten += uint64(len(run))
+			d.stats.QueueDepth = len(d.pending) + len(d.writing)
+			d.cond.Broadcast()
+			d.mu.Unlock()
+
+			for _, p := range run {
+				if p.done != nil {
+					p.done(err)
+				}
+			}
+			start = end
+		}
+	}
+}
+
+/*
+writeRun writes pieces at consecutive indices, joined into one buffer and
+written with one call if the buffer fits the budget next to the cache, with
+one call per piece otherwise. It returns the number of calls.
+*/
+func (d *DiskIO) writeRun(run []dirtyPiece) (uint64, error) {
+	offset := uint64(run[0].index) * d.t.Info.PieceLength
+	if len(run) == 1 {
+		return 1, d.storage.WriteAt(run[0].data, offset)
+	}
+
+	var size int64
+	for _, p := range run {
+		size += int64(len(p.data))
+	}
+	d.mu.Lock()
+	d.evict(size)
+	join := d.used()+size <= d.budget
+	if join {
+		d.joining += size
+	}
+	d.mu.Unlock()
+
+	if !join {
+		for i, p := range run {
+			if err := d.storage.WriteAt(p.data, offset); err != nil {
+				return uint64(i + 1), err
+			}
+			offset += uint64(len(p.data))
+		}
+		return uint64(len(run)), nil
+	}
+
+	buf := make([]byte, 0, size)
+	for _, p := range run {
+		buf = append(buf, p.data...)
+	}
+	err := d.storage.WriteAt(buf, offset)
+	d.mu.Lock()
+	d.joining -= size
+	d.mu.Unlock()
+	return 1, err
+}
+
+// Flush blocks until every queued piece has been written
+func (d *DiskIO) Flush() error {
+	d.mu.Lock()
+	for len(d.pending) > 0 || len(d.writing) > 0 {
+		d.cond.Wait()
+	}
+	d.mu.Unlock()
+	return d.storage.Sync()
+}
+
+func (d *DiskIO) Stats() DiskStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats
+}
+
+// Close writes out whatever is still queued and closes the storage's files
+func (d *DiskIO) Close() error {
+	d.Flush()
+	d.mu.Lock()
+	d.closed = true
+	d.cond.Broadcast()
+	d.mu.Unlock()
+	return d.storage.Close()
+}
@@ -0,0 +1,139 @@
+package download
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"torrent-client/src/parser"
+)
+
+const testPieceLength = 16
+
+// diskTorrent makes a single file torrent of pieces pieces and a DiskIO with budget over it
+func diskTorrent(t *testing.T, pieces int, budget int64) (*parser.Torrent, []byte, *DiskIO) {
+	t.Helper()
+	data := make([]byte, pieces*testPieceLength)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	torrent := seedTorrent("data", []parser.InfoFile{{Length: uint64(len(data))}}, data, testPieceLength)
+	d := NewDiskIO(torrent, NewStorage(torrent, t.TempDir()), budget)
+	t.Cleanup(func() { d.Close() })
+	return torrent, data, d
+}
+
+func piece(data []byte, i int) []byte {
+	return data[i*testPieceLength : (i+1)*testPieceLength]
+}
+
+func cachedPieces(d *DiskIO) []uint32 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var pieces []uint32
+	for i := range d.cleanIdx {
+		pieces = append(pieces, i)
+	}
+	slices.Sort(pieces)
+	return pieces
+}
+
+func TestDiskIOCacheReplacesPiece(t *testing.T) {
+	_, data, d := diskTorrent(t, 2, DISK_CACHE_SIZE)
+
+	for range 2 {
+		d.Write(0, piece(data, 0), nil)
+		if err := d.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st := d.Stats()
+	if st.CachedBytes != testPieceLength || d.clean.Len() != 1 {
+		t.Errorf("writing a piece twice caches %d bytes in %d entries, want %d in 1", st.CachedBytes, d.clean.Len(), testPieceLength)
+	}
+
+	block, err := d.Read(0, 4, 8, []byte{0x80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(block, piece(data, 0)[4:12]) {
+		t.Errorf("read %v", block)
+	}
+	if st := d.Stats(); st.CacheHits != 1 || st.CacheMisses != 0 {
+		t.Errorf("%d hits and %d misses, want a hit", st.CacheHits, st.CacheMisses)
+	}
+}
+
+func TestDiskIOReadAheadOnlyHavePieces(t *testing.T) {
+	_, data, d := diskTorrent(t, 4, DISK_CACHE_SIZE)
+	// on disk without going through the cache
+	if err := d.Storage().WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	have := []byte{0b1101_0000} // pieces 0, 1 and 3
+	block, err := d.Read(0, 0, testPieceLength, have)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(block, piece(data, 0)) {
+		t.Errorf("read %v", block)
+	}
+	if got := cachedPieces(d); !slices.Equal(got, []uint32{0, 1}) {
+		t.Errorf("cached pieces %v, want 0 and 1", got)
+	}
+	if _, err := d.Read(1, 0, 1, have); err != nil {
+		t.Fatal(err)
+	}
+	if st := d.Stats(); st.CacheHits != 1 || st.CacheMisses != 1 {
+		t.Errorf("%d hits and %d misses, want 1 and 1", st.CacheHits, st.CacheMisses)
+	}
+
+	if _, err := d.Read(0, testPieceLength-1, 2, have); err == nil {
+		t.Error("a block past the end of the piece was read")
+	}
+}
+
+func TestDiskIOBudget(t *testing.T) {
+	const budget = 2 * testPieceLength
+	_, data, d := diskTorrent(t, 6, budget)
+
+	for i := range 6 {
+		d.Write(uint32(i), piece(data, i), nil)
+		if st := d.Stats(); st.DirtyBytes+st.CachedBytes > budget {
+			t.Fatalf("%d dirty and %d cached bytes over a budget of %d", st.DirtyBytes, st.CachedBytes, budget)
+		}
+	}
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	st := d.Stats()
+	if st.DirtyBytes != 0 || st.CachedBytes > budget || st.PiecesWritten != 6 {
+		t.Errorf("after flushing: %+v", st)
+	}
+	if got := cachedPieces(d); !slices.Equal(got, []uint32{4, 5}) {
+		t.Errorf("cached pieces %v, want the last two written", got)
+	}
+}
+
+func TestDiskIOCoalescesConsecutivePieces(t *testing.T) {
+	torrent, data, d := diskTorrent(t, 4, DISK_CACHE_SIZE)
+
+	// hold the writer up so the pieces queue behind the first one
+	d.storage.io.Lock()
+	for i := range 4 {
+		d.Write(uint32(i), piece(data, i), nil)
+	}
+	d.storage.io.Unlock()
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	st := d.Stats()
+	if st.PiecesWritten != 4 || st.WriteCalls > 2 {
+		t.Errorf("%d pieces written with %d calls, want 4 with at most 2", st.PiecesWritten, st.WriteCalls)
+	}
+	got, err := ReadPiece(torrent, Layout(torrent), d.Storage().BaseDir(), 2)
+	if err != nil || !bytes.Equal(got, piece(data, 2)) {
+		t.Errorf("piece 2 on disk is %v (%v)", got, err)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !freebsd
+
+package download
+
+import (
+	"fmt"
+	"os"
+)
+
+func freeSpace(dir string) (uint64, error) {
+	return 0, fmt.Errorf("free space check is not supported on this platform")
+}
+
+// allocatedSize is the size of the file, sparse files cannot be told apart here
+func allocatedSize(info os.FileInfo) uint64 {
+	return uint64(info.Size())
+}
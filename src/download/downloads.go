@@ -82,12 +82,9 @@ func GetNextDownloadablePiece(bitfield []byte, downloaded []byte) (int, int, err
 	return downloadIndex, bitIndex, nil
 }
 
-func DownloadPiece(conn net.Conn, bitfield []byte, t *parser.Torrent, pieceIndex uint32) ([]byte, error) {
-	pieceLen := t.Info.PieceLength
-	if pieceIndex == t.Info.PieceCount-1 && t.TotalLength%t.Info.PieceLength != 0 {
-		pieceLen = t.TotalLength % t.Info.PieceLength
-		// fmt.Println("Last piece is smaller than the rest, len:", pieceLen)
-	}
+// FetchPiece requests all blocks of a piece from a peer without verifying the result
+func FetchPiece(conn net.Conn, bitfield []byte, t *parser.Torrent, pieceIndex uint32) ([]byte, error) {
+	pieceLen := PieceLength(t, pieceIndex)
 	begin := uint32(0)
 	piece := make([]byte, pieceLen)
 
@@ -109,14 +106,14 @@ func DownloadPiece(conn net.Conn, bitfield []byte, t *parser.Torrent, pieceIndex
 			break
 		}
 	}
+	return piece, nil
+}
 
-	// verify the downloaded piece
+// CheckPiece compares a piece's hash, computed by the shared hashing pool, with the torrent's
+func CheckPiece(t *parser.Torrent, pieceIndex uint32, hash []byte) error {
 	expected := t.Info.PieceHashes[pieceIndex]
-	computed := parser.GetSha1Hash(piece)
-	if !bytes.Equal(computed, expected) {
-		return nil, fmt.Errorf("expected %x, got %x", expected, computed)
+	if !bytes.Equal(hash, expected) {
+		return fmt.Errorf("expected %x, got %x", expected, hash)
 	}
-
-	// fmt.Println("Downloaded piece index:", pieceIndex)
-	return piece, nil
+	return nil
 }
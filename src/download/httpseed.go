@@ -0,0 +1,89 @@
+package download
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"torrent-client/src/parser"
+)
+
+/*
+HTTPSeed downloads pieces from a BEP 17 (httpseeds) server, which serves a
+piece at a time:
+
+	<seed url>?info_hash=<info hash>&piece=<index>
+
+A request may narrow a piece down with ranges=<first>-<last>,..., the whole
+piece is asked for here so it is left out. A busy seed answers 503 with the
+number of seconds to wait in the body, returned as a *RetryError.
+*/
+
+const MAX_RETRY_BODY = 64
+
+type HTTPSeed struct {
+	url      string
+	client   *http.Client
+	infoHash []byte
+}
+
+func NewHTTPSeed(t *parser.Torrent, seed string, client *http.Client) *HTTPSeed {
+	return &HTTPSeed{url: seed, client: client, infoHash: t.InfoHash}
+}
+
+func (h *HTTPSeed) URL() string {
+	return h.url
+}
+
+func (h *HTTPSeed) pieceURL(pieceIndex uint32) (string, error) {
+	u, err := url.Parse(h.url)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("info_hash", string(h.infoHash))
+	q.Set("piece", strconv.FormatUint(uint64(pieceIndex), 10))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func (h *HTTPSeed) FetchPiece(t *parser.Torrent, pieceIndex uint32) ([]byte, error) {
+	pieceURL, err := h.pieceURL(pieceIndex)
+	if err != nil {
+		return nil, err
+	}
+	res, err := h.client.Get(pieceURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusServiceUnavailable:
+		body, _ := io.ReadAll(io.LimitReader(res.Body, MAX_RETRY_BODY))
+		if seconds, err := strconv.Atoi(strings.TrimSpace(string(body))); err == nil && seconds >= 0 {
+			return nil, newRetryError(time.Duration(seconds) * time.Second)
+		}
+		if after, ok := retryAfter(res); ok {
+			return nil, newRetryError(after)
+		}
+		return nil, fmt.Errorf("%s: %s", h.url, res.Status)
+	default:
+		return nil, fmt.Errorf("%s: %s", h.url, res.Status)
+	}
+
+	length := PieceLength(t, pieceIndex)
+	piece := make([]byte, length)
+	if _, err := io.ReadFull(res.Body, piece); err != nil {
+		return nil, fmt.Errorf("%s: piece %d: %w", h.url, pieceIndex, err)
+	}
+	// a longer answer is not the piece asked for
+	if n, _ := res.Body.Read(make([]byte, 1)); n > 0 {
+		return nil, fmt.Errorf("%s: piece %d is longer than %d bytes", h.url, pieceIndex, length)
+	}
+	return piece, nil
+}
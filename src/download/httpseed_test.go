@@ -0,0 +1,85 @@
+package download
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"torrent-client/src/parser"
+)
+
+func TestHTTPSeedPieces(t *testing.T) {
+	data := []byte(strings.Repeat("0123456789", 5))
+	torrent := seedTorrent("f", []parser.InfoFile{{Length: uint64(len(data))}}, data, 16)
+	torrent.InfoHash = bytes.Repeat([]byte{0xab}, 20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		index, err := strconv.ParseUint(q.Get("piece"), 10, 32)
+		if q.Get("info_hash") != string(torrent.InfoHash) || err != nil || index >= uint64(torrent.Info.PieceCount) {
+			http.NotFound(w, r)
+			return
+		}
+		start := index * torrent.Info.PieceLength
+		w.Write(data[start:min(start+torrent.Info.PieceLength, uint64(len(data)))])
+	}))
+	defer srv.Close()
+
+	if got := fetchAll(t, NewHTTPSeed(torrent, srv.URL+"/seed", testClient()), torrent); !bytes.Equal(got, data) {
+		t.Errorf("got %q, want %q", got, data)
+	}
+}
+
+func TestHTTPSeedErrors(t *testing.T) {
+	data := []byte(strings.Repeat("x", 32))
+	busy := func(after time.Duration) func(err error) bool {
+		return func(err error) bool {
+			var retry *RetryError
+			return errors.As(err, &retry) && retry.After == after
+		}
+	}
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		check   func(err error) bool
+	}{
+		{"busy", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("120"))
+		}, busy(120 * time.Second)},
+		{"busy without delay", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("0"))
+		}, busy(SEED_MIN_RETRY)},
+		{"busy with Retry-After", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, busy(SEED_MIN_RETRY)},
+		{"unavailable", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, func(err error) bool {
+			var retry *RetryError
+			return err != nil && !errors.As(err, &retry)
+		}},
+		{"too long", func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data[:17])
+		}, func(err error) bool { return err != nil && strings.Contains(err.Error(), "longer") }},
+		{"too short", func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data[:15])
+		}, func(err error) bool { return err != nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			torrent := seedTorrent("f", []parser.InfoFile{{Length: uint64(len(data))}}, data, 16)
+			piece, err := NewHTTPSeed(torrent, srv.URL, testClient()).FetchPiece(torrent, 0)
+			if !tt.check(err) {
+				t.Errorf("unexpected result: %v (piece %q)", err, piece)
+			}
+		})
+	}
+}
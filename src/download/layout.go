@@ -0,0 +1,133 @@
+package download
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"torrent-client/src/parser"
+)
+
+/*
+The torrent's pieces are laid over its files back to back, so a piece can
+end in one file and continue in the next one.
+
+	|----- file 0 -----|-- file 1 --|------- file 2 -------|
+	|  piece 0  |  piece 1  |  piece 2  |  piece 3  | p 4  |
+*/
+
+type LayoutFile struct {
+	Path   string // sanitized, relative to the torrent's base directory
+	Offset uint64 // offset of the file's first byte in the torrent
+	Length uint64
+}
+
+type FileSpan struct {
+	Path   string
+	Offset int64 // offset inside the file
+	Length int64
+}
+
+func Layout(t *parser.Torrent) []LayoutFile {
+	if !t.HasMultipleFiles {
+		return []LayoutFile{{Path: SafeName(t), Offset: 0, Length: t.TotalLength}}
+	}
+
+	files := make([]LayoutFile, 0, len(t.Info.Files))
+	paths := SafePaths(t.Info.Files)
+	var offset uint64
+	for i, f := range t.Info.Files {
+		files = append(files, LayoutFile{Path: paths[i], Offset: offset, Length: f.Length})
+		offset += f.Length
+	}
+	return files
+}
+
+func PieceLength(t *parser.Torrent, pieceIndex uint32) uint64 {
+	if pieceIndex == t.Info.PieceCount-1 && t.TotalLength%t.Info.PieceLength != 0 {
+		return t.TotalLength % t.Info.PieceLength
+	}
+	return t.Info.PieceLength
+}
+
+// Spans returns the parts of files covering length bytes starting at offset in the torrent
+func Spans(files []LayoutFile, offset uint64, length uint64) []FileSpan {
+	var spans []FileSpan
+	end := offset + length
+	for _, f := range files {
+		fileEnd := f.Offset + f.Length
+		if fileEnd <= offset || f.Length == 0 {
+			continue
+		}
+		if f.Offset >= end {
+			break
+		}
+		start := max(offset, f.Offset)
+		stop := min(end, fileEnd)
+		spans = append(spans, FileSpan{Path: f.Path, Offset: int64(start - f.Offset), Length: int64(stop - start)})
+	}
+	return spans
+}
+
+func PieceSpans(t *parser.Torrent, files []LayoutFile, pieceIndex uint32) []FileSpan {
+	return Spans(files, uint64(pieceIndex)*t.Info.PieceLength, PieceLength(t, pieceIndex))
+}
+
+// isTorrentFile tells whether path is one of the torrent's own files, which then cannot be a piece file of an older version
+func isTorrentFile(files []LayoutFile, path string) bool {
+	for _, f := range files {
+		if f.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+PieceFiles maps a piece to the files holding it for the resume data: the
+piece file while the piece has not been assembled yet, the output files
+afterwards.
+*/
+func PieceFiles(t *parser.Torrent, baseDir string) func(pieceIndex uint32) []string {
+	files := Layout(t)
+	return func(pieceIndex uint32) []string {
+		name := PieceFileName(pieceIndex)
+		if _, err := os.Stat(filepath.Join(baseDir, name)); err == nil && !isTorrentFile(files, name) {
+			return []string{name}
+		}
+
+		var paths []string
+		for _, span := range PieceSpans(t, files, pieceIndex) {
+			paths = append(paths, span.Path)
+		}
+		return paths
+	}
+}
+
+// ReadPiece reads a piece from its piece file if there is one, otherwise from the output files
+func ReadPiece(t *parser.Torrent, files []LayoutFile, baseDir string, pieceIndex uint32) ([]byte, error) {
+	if name := PieceFileName(pieceIndex); !isTorrentFile(files, name) {
+		if piece, err := os.ReadFile(filepath.Join(baseDir, name)); err == nil {
+			return piece, nil
+		}
+	}
+
+	piece := make([]byte, PieceLength(t, pieceIndex))
+	var pos int64
+	for _, span := range PieceSpans(t, files, pieceIndex) {
+		path := filepath.Join(baseDir, span.Path)
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		_, err = f.ReadAt(piece[pos:pos+span.Length], span.Offset)
+		f.Close()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s is shorter than expected", path)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		pos += span.Length
+	}
+	return piece, nil
+}
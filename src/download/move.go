@@ -0,0 +1,109 @@
+package download
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+/*
+Move relocates the torrent's data to newOutDir while the torrent keeps
+running. Reads and writes wait until the move is done and then continue on
+the files at the new location. The data is renamed when both directories
+are on the same filesystem and copied (keeping modification times, which
+the resume data relies on) and then removed otherwise.
+*/
+func (s *Storage) Move(newOutDir string) error {
+	s.io.Lock()
+	defer s.io.Unlock()
+
+	if err := s.closeFiles(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	src := s.baseDir
+	s.mu.Unlock()
+	dst := BaseDir(s.t, newOutDir)
+
+	if filepath.Clean(src) == filepath.Clean(dst) {
+		return nil
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("destination %s already exists", dst)
+	}
+	if err := os.MkdirAll(newOutDir, 0755); err != nil {
+		return fmt.Errorf("could not create %s: %w", newOutDir, err)
+	}
+
+	if _, err := os.Stat(src); err == nil {
+		if err := os.Rename(src, dst); err != nil {
+			// most likely another filesystem, fall back to copying
+			if err := copyTree(src, dst); err != nil {
+				os.RemoveAll(dst)
+				return fmt.Errorf("failed to move %s to %s: %w", src, dst, err)
+			}
+			if err := os.RemoveAll(src); err != nil {
+				return fmt.Errorf("copied to %s but failed to remove %s: %w", dst, src, err)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.outDir = newOutDir
+	s.baseDir = dst
+	s.mu.Unlock()
+	return nil
+}
+
+func copyTree(src string, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if err := copyFile(path, target, info.Mode().Perm()); err != nil {
+			return err
+		}
+		return os.Chtimes(target, info.ModTime(), info.ModTime())
+	})
+}
+
+func copyFile(src string, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
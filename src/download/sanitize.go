@@ -0,0 +1,119 @@
+package download
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"torrent-client/src/parser"
+	"unicode/utf8"
+)
+
+/*
+Paths in the metainfo come from whoever made the torrent, so every name is
+mapped to something that stays inside the output directory and is valid on
+any platform before it touches the disk:
+
+- invalid UTF-8, control characters (NUL included), path separators and
+  <>:"|?* are replaced with _
+- trailing dots and spaces are dropped, "", "." and ".." become _
+- reserved device names (CON, NUL, COM1, ...) get a _ prefix
+- components longer than 255 bytes are cut, keeping the extension
+- a path that collides with an earlier file or directory (ignoring case)
+  gets " (n)" added before its extension, n counting up from 1
+*/
+
+const MAX_COMPONENT_LENGTH = 255
+
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+func SanitizeComponent(name string) string {
+	name = strings.ToValidUTF8(name, "_")
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return "_"
+	}
+
+	base := strings.ToUpper(strings.SplitN(name, ".", 2)[0])
+	if reservedNames[base] {
+		name = "_" + name
+	}
+	return truncateComponent(name, "")
+}
+
+// truncateComponent fits name and suffix (added before the extension) into MAX_COMPONENT_LENGTH bytes
+func truncateComponent(name string, suffix string) string {
+	ext := filepath.Ext(name)
+	if len(ext) > 32 {
+		ext = ""
+	}
+	base := name[:len(name)-len(ext)]
+
+	limit := MAX_COMPONENT_LENGTH - len(ext) - len(suffix)
+	if len(base) > limit {
+		base = base[:limit]
+		// do not cut a multi-byte character in half
+		for len(base) > 0 && !utf8.ValidString(base) {
+			base = base[:len(base)-1]
+		}
+	}
+	return base + suffix + ext
+}
+
+// SafeName is the sanitized name of the torrent's base directory (and of the file of a single file torrent)
+func SafeName(t *parser.Torrent) string {
+	return SanitizeComponent(t.Info.Name)
+}
+
+func BaseDir(t *parser.Torrent, outDir string) string {
+	return filepath.Join(outDir, SafeName(t))
+}
+
+// SafePaths returns the sanitized, collision free relative path of every file of a multi file torrent
+func SafePaths(files []parser.InfoFile) []string {
+	usedFiles := make(map[string]bool)
+	usedDirs := make(map[string]bool)
+	paths := make([]string, 0, len(files))
+
+	for _, f := range files {
+		parts := f.Path
+		if len(parts) == 0 {
+			parts = []string{""}
+		}
+
+		var dir string
+		for i, part := range parts {
+			name := SanitizeComponent(part)
+			last := i == len(parts)-1
+
+			candidate := name
+			for n := 1; ; n++ {
+				key := strings.ToLower(filepath.Join(dir, candidate))
+				// a directory may be shared with earlier files, a file name may not be reused at all
+				taken := usedFiles[key] || (last && usedDirs[key])
+				if !taken {
+					break
+				}
+				candidate = truncateComponent(name, " ("+strconv.Itoa(n)+")")
+			}
+
+			dir = filepath.Join(dir, candidate)
+			if last {
+				usedFiles[strings.ToLower(dir)] = true
+			} else {
+				usedDirs[strings.ToLower(dir)] = true
+			}
+		}
+		paths = append(paths, dir)
+	}
+	return paths
+}
@@ -0,0 +1,164 @@
+package download
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"torrent-client/src/parser"
+)
+
+// craftTorrent bencodes a torrent with the given name and files (a single file torrent when files is nil) and decodes it
+func craftTorrent(t *testing.T, name string, files [][]string) *parser.Torrent {
+	t.Helper()
+	bstr := func(s string) string { return fmt.Sprintf("%d:%s", len(s), s) }
+
+	info := "d"
+	if files != nil {
+		info += "5:filesl"
+		for _, path := range files {
+			info += "d6:lengthi1e4:pathl"
+			for _, part := range path {
+				info += bstr(part)
+			}
+			info += "ee"
+		}
+		info += "e"
+	} else {
+		info += "6:lengthi1e"
+	}
+	info += "4:name" + bstr(name) + "12:piece lengthi16384e6:pieces" + bstr(strings.Repeat("\x00", 20)) + "e"
+
+	torrent, err := parser.DecodeTorrent([]byte("d8:announce" + bstr("http://tracker/announce") + "4:info" + info + "e"))
+	if err != nil {
+		t.Fatalf("decoding crafted torrent: %v", err)
+	}
+	return torrent
+}
+
+func TestSanitizeComponent(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "file.txt", "file.txt"},
+		{"empty", "", "_"},
+		{"dot", ".", "_"},
+		{"dot dot", "..", "_"},
+		{"absolute", "/etc", "_etc"},
+		{"absolute path", "/etc/passwd", "_etc_passwd"},
+		{"backslash", `..\..\x`, ".._.._x"},
+		{"nul byte", "a\x00b", "a_b"},
+		{"control characters", "a\nb\x7f", "a_b_"},
+		{"windows characters", `a<b>c:d"e|f?g*h`, "a_b_c_d_e_f_g_h"},
+		{"invalid utf-8", "a\xffb", "a_b"},
+		{"trailing dots and spaces", "name. . ", "name"},
+		{"reserved", "CON", "_CON"},
+		{"reserved with extension", "CON.txt", "_CON.txt"},
+		{"reserved lower case", "nul.tar.gz", "_nul.tar.gz"},
+		{"reserved prefix only", "CONSOLE.txt", "CONSOLE.txt"},
+		{"over-long", long + ".txt", long[:MAX_COMPONENT_LENGTH-4] + ".txt"},
+		{"over-long multi-byte", strings.Repeat("é", 200), strings.Repeat("é", 127)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeComponent(tt.in); got != tt.want {
+				t.Errorf("SanitizeComponent(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSafeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"..", "_"},
+		{"/etc/passwd", "_etc_passwd"},
+		{"COM1", "_COM1"},
+		{"movie\x00.mkv", "movie_.mkv"},
+	}
+	for _, tt := range tests {
+		if got := SafeName(craftTorrent(t, tt.name, nil)); got != tt.want {
+			t.Errorf("SafeName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSafePaths(t *testing.T) {
+	long := strings.Repeat("b", 300)
+	tests := []struct {
+		name  string
+		files [][]string
+		want  []string
+	}{
+		{"traversal", [][]string{{"..", "..", "etc", "passwd"}}, []string{"_/_/etc/passwd"}},
+		{"absolute", [][]string{{"/etc", "passwd"}, {"", "root"}}, []string{"_etc/passwd", "_/root"}},
+		{"empty path", [][]string{{}}, []string{"_"}},
+		{"reserved", [][]string{{"dir", "CON.txt"}, {"aux"}}, []string{"dir/_CON.txt", "_aux"}},
+		{"nul byte", [][]string{{"a\x00", "b"}}, []string{"a_/b"}},
+		{
+			"case collision",
+			[][]string{{"Readme.txt"}, {"README.TXT"}, {"readme.txt"}},
+			[]string{"Readme.txt", "README (1).TXT", "readme (2).txt"},
+		},
+		{
+			"collision after sanitizing",
+			[][]string{{"a:b"}, {"a?b"}},
+			[]string{"a_b", "a_b (1)"},
+		},
+		{
+			"file and directory collision",
+			[][]string{{"x"}, {"X", "y"}, {"d", "f"}, {"D"}},
+			[]string{"x", "X (1)/y", "d/f", "D (1)"},
+		},
+		{"shared directory", [][]string{{"dir", "a"}, {"DIR", "b"}}, []string{"dir/a", "DIR/b"}},
+		{
+			"over-long collision",
+			[][]string{{long + ".txt"}, {long + ".txt"}},
+			[]string{long[:MAX_COMPONENT_LENGTH-4] + ".txt", long[:MAX_COMPONENT_LENGTH-8] + " (1).txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			torrent := craftTorrent(t, "torrent", tt.files)
+			got := SafePaths(torrent.Info.Files)
+			if len(got) != len(tt.want) {
+				t.Fatalf("SafePaths returned %d paths, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != filepath.FromSlash(tt.want[i]) {
+					t.Errorf("path %d = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// every file of a hostile torrent must land inside its base directory
+func TestLayoutStaysInside(t *testing.T) {
+	torrent := craftTorrent(t, "../../escape", [][]string{
+		{"..", "..", "..", "tmp", "x"},
+		{"/", "etc", "shadow"},
+		{"C:", "Windows", "win.ini"},
+		{`..\..\boot.ini`},
+		{"a\x00/../../b"},
+	})
+	base := BaseDir(torrent, "out")
+	if filepath.Dir(base) != "out" {
+		t.Errorf("base directory %q is not directly inside out", base)
+	}
+	for _, f := range Layout(torrent) {
+		if !filepath.IsLocal(f.Path) {
+			t.Errorf("%q escapes the base directory", f.Path)
+		}
+		for _, part := range strings.Split(f.Path, string(filepath.Separator)) {
+			if part == ".." || part == "." || part == "" {
+				t.Errorf("%q has the component %q", f.Path, part)
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package download
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"torrent-client/src/parser"
+)
+
+/*
+What the two kinds of HTTP seeds have in common: both hand out whole pieces
+through Seed and report a busy server as a *RetryError with the time it asked
+for, but at least SEED_MIN_RETRY so a seed answering 0 or nothing is not
+asked again in a tight loop.
+*/
+
+const SEED_MIN_RETRY = 5 * time.Second
+
+// Seed is an HTTP server that has every piece of the torrent, a BEP 19 WebSeed or a BEP 17 HTTPSeed
+type Seed interface {
+	URL() string
+	// FetchPiece downloads a piece without verifying it
+	FetchPiece(t *parser.Torrent, pieceIndex uint32) ([]byte, error)
+}
+
+// RetryError is a seed that is busy and asks to come back After a while
+type RetryError struct {
+	After time.Duration
+}
+
+func newRetryError(after time.Duration) *RetryError {
+	return &RetryError{After: max(after, SEED_MIN_RETRY)}
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("seed is busy, retry in %s", e.After)
+}
+
+// retryAfter reads the Retry-After header of a 503 response, seconds or an HTTP date
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	value := res.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,127 @@
+package download
+
+import (
+	"bytes"
+	"sync"
+	"torrent-client/src/hashing"
+	"torrent-client/src/parser"
+)
+
+/*
+SmartBan finds out who sent the bad data when a piece fails its hash check.
+
+Every failed attempt remembers which peer sent each block together with the
+block's hash. Once the piece finally verifies, the blocks of the failed
+attempts are compared with the good data: whoever sent a block that differs
+sent corrupt data and is banned for the session. Peers that keep taking part
+in failed pieces are banned as well, even before the piece verifies.
+*/
+
+const FAILURES_TO_BAN = 3     // failed pieces a peer may be part of before it gets banned
+const MAX_FAILED_ATTEMPTS = 5 // failed attempts remembered per piece
+
+type blockRecord struct {
+	peer string
+	hash []byte
+}
+
+type SmartBan struct {
+	mu       sync.Mutex
+	attempts map[uint32][][]blockRecord // piece index -> failed attempts -> blocks
+	failures map[string]int
+	banned   map[string]bool
+}
+
+func NewSmartBan() *SmartBan {
+	return &SmartBan{
+		attempts: make(map[uint32][][]blockRecord),
+		failures: make(map[string]int),
+		banned:   make(map[string]bool),
+	}
+}
+
+// BlockSources is the source list of a piece downloaded from a single peer
+func BlockSources(t *parser.Torrent, pieceIndex uint32, peer string) []string {
+	count := (PieceLength(t, pieceIndex) + uint64(BLOCK_SIZE) - 1) / uint64(BLOCK_SIZE)
+	sources := make([]string, count)
+	for i := range sources {
+		sources[i] = peer
+	}
+	return sources
+}
+
+func blockHashes(piece []byte, sources []string) []blockRecord {
+	blocks := make([]blockRecord, 0, len(sources))
+	for i, peer := range sources {
+		begin := i * int(BLOCK_SIZE)
+		end := min(begin+int(BLOCK_SIZE), len(piece))
+		if begin >= end {
+			break
+		}
+		blocks = append(blocks, blockRecord{peer: peer, hash: hashing.Default.Hash(piece[begin:end])})
+	}
+	return blocks
+}
+
+/*
+PieceFailed records a failed attempt at a piece, sources[i] being the peer
+that sent block i. It returns the peers that are banned because of it.
+*/
+func (b *SmartBan) PieceFailed(pieceIndex uint32, piece []byte, sources []string) []string {
+	blocks := blockHashes(piece, sources)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.attempts[pieceIndex]) < MAX_FAILED_ATTEMPTS {
+		b.attempts[pieceIndex] = append(b.attempts[pieceIndex], blocks)
+	}
+
+	var banned []string
+	implicated := make(map[string]bool)
+	for _, block := range blocks {
+		implicated[block.peer] = true
+	}
+	for peer := range implicated {
+		b.failures[peer]++
+		if b.failures[peer] >= FAILURES_TO_BAN && !b.banned[peer] {
+			b.banned[peer] = true
+			banned = append(banned, peer)
+		}
+	}
+	return banned
+}
+
+// PieceVerified bans the peers whose blocks in earlier failed attempts differ from the verified piece
+func (b *SmartBan) PieceVerified(pieceIndex uint32, piece []byte) []string {
+	b.mu.Lock()
+	attempts := b.attempts[pieceIndex]
+	delete(b.attempts, pieceIndex)
+	b.mu.Unlock()
+
+	if len(attempts) == 0 {
+		return nil
+	}
+
+	sources := make([]string, len(attempts[0]))
+	good := blockHashes(piece, sources)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var banned []string
+	for _, attempt := range attempts {
+		for i, block := range attempt {
+			if i < len(good) && !bytes.Equal(block.hash, good[i].hash) && !b.banned[block.peer] {
+				b.banned[block.peer] = true
+				banned = append(banned, block.peer)
+			}
+		}
+	}
+	return banned
+}
+
+func (b *SmartBan) Banned(peer string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.banned[peer]
+}
@@ -0,0 +1,67 @@
+package download
+
+import (
+	"slices"
+	"testing"
+)
+
+// twoBlocks is a piece of two blocks, the second one corrupted when bad is set
+func twoBlocks(bad bool) []byte {
+	piece := make([]byte, 2*BLOCK_SIZE)
+	for i := range piece {
+		piece[i] = byte(i)
+	}
+	if bad {
+		piece[BLOCK_SIZE+7] ^= 0xff
+	}
+	return piece
+}
+
+func TestSmartBanBansSenderOfBadBlock(t *testing.T) {
+	ban := NewSmartBan()
+
+	if banned := ban.PieceFailed(3, twoBlocks(true), []string{"good", "bad"}); len(banned) != 0 {
+		t.Errorf("banned %v on the first failure", banned)
+	}
+	if banned := ban.PieceVerified(3, twoBlocks(false)); !slices.Equal(banned, []string{"bad"}) {
+		t.Errorf("banned %v, want the sender of the corrupt block", banned)
+	}
+	if !ban.Banned("bad") || ban.Banned("good") {
+		t.Errorf("banned: bad %v, good %v", ban.Banned("bad"), ban.Banned("good"))
+	}
+
+	// the failed attempts are forgotten once the piece verified
+	if banned := ban.PieceVerified(3, twoBlocks(false)); len(banned) != 0 {
+		t.Errorf("banned %v again", banned)
+	}
+}
+
+func TestSmartBanRepeatedFailures(t *testing.T) {
+	ban := NewSmartBan()
+
+	var banned []string
+	for i := range uint32(FAILURES_TO_BAN) {
+		if len(banned) != 0 {
+			t.Fatalf("banned %v after %d failures", banned, i)
+		}
+		// "other" only takes part in the first failure
+		sources := []string{"repeat", "repeat"}
+		if i == 0 {
+			sources[1] = "other"
+		}
+		banned = ban.PieceFailed(i, twoBlocks(true), sources)
+	}
+	if !slices.Equal(banned, []string{"repeat"}) || ban.Banned("other") {
+		t.Errorf("banned %v after %d failures, want only repeat", banned, FAILURES_TO_BAN)
+	}
+}
+
+func TestSmartBanKeepsFewAttempts(t *testing.T) {
+	ban := NewSmartBan()
+	for i := range MAX_FAILED_ATTEMPTS + 3 {
+		ban.PieceFailed(0, twoBlocks(true), []string{"a", string(rune('b' + i))})
+	}
+	if n := len(ban.attempts[0]); n != MAX_FAILED_ATTEMPTS {
+		t.Errorf("%d attempts remembered, want %d", n, MAX_FAILED_ATTEMPTS)
+	}
+}
@@ -0,0 +1,202 @@
+package download
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"torrent-client/src/parser"
+)
+
+/*
+Storage reads and writes the torrent's data straight into its output files,
+addressed by offset in the torrent (see Layout). Files are created on the
+first write and opened on first use. At most MAX_OPEN_FILES stay open, the
+least recently used handle that is not in use is closed to make room (after
+a sync if it was written to), so torrents with thousands of files do not run
+out of file descriptors.
+
+Reads and writes hold io for reading, Move holds it for writing so no I/O
+happens while the files are being moved.
+*/
+
+const MAX_OPEN_FILES = 128
+
+type handle struct {
+	path    string
+	f       *os.File
+	refs    int  // reads and writes using the handle right now
+	written bool // since it was last synced
+	el      *list.Element
+}
+
+type Storage struct {
+	io      sync.RWMutex
+	mu      sync.Mutex
+	t       *parser.Torrent
+	outDir  string
+	baseDir string
+	files   []LayoutFile
+	handles map[string]*handle
+	lru     *list.List // of *handle, most recently used in front
+}
+
+func NewStorage(t *parser.Torrent, outDir string) *Storage {
+	return &Storage{
+		t:       t,
+		outDir:  outDir,
+		baseDir: BaseDir(t, outDir),
+		files:   Layout(t),
+		handles: make(map[string]*handle),
+		lru:     list.New(),
+	}
+}
+
+func (s *Storage) OutDir() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.outDir
+}
+
+func (s *Storage) BaseDir() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.baseDir
+}
+
+/*
+open returns the handle of a file, which the caller gives back with release.
+Only writes create missing files, a read of a missing file fails.
+*/
+func (s *Storage) open(path string, write bool) (*handle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if h, ok := s.handles[path]; ok {
+		h.refs++
+		h.written = h.written || write
+		s.lru.MoveToFront(h.el)
+		return h, nil
+	}
+
+	fullPath := filepath.Join(s.baseDir, path)
+	flag := os.O_RDWR
+	if write {
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			return nil, fmt.Errorf("could not create necessary directory for %s: %w", fullPath, err)
+		}
+		flag |= os.O_CREATE
+	}
+	f, err := os.OpenFile(fullPath, flag, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", fullPath, err)
+	}
+	s.evict(MAX_OPEN_FILES - 1)
+
+	h := &handle{path: path, f: f, refs: 1, written: write}
+	h.el = s.lru.PushFront(h)
+	s.handles[path] = h
+	return h, nil
+}
+
+func (s *Storage) release(h *handle) {
+	s.mu.Lock()
+	h.refs--
+	s.mu.Unlock()
+}
+
+// evict closes least recently used handles nobody is using until at most keep are open, the caller holds s.mu
+func (s *Storage) evict(keep int) error {
+	var firstErr error
+	for el := s.lru.Back(); el != nil && s.lru.Len() > keep; {
+		h := el.Value.(*handle)
+		el = el.Prev()
+		if h.refs > 0 {
+			continue
+		}
+		if h.written {
+			if err := h.f.Sync(); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("failed to sync %s: %w", h.path, err)
+			}
+		}
+		if err := h.f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		s.lru.Remove(h.el)
+		delete(s.handles, h.path)
+	}
+	return firstErr
+}
+
+func (s *Storage) WriteAt(b []byte, offset uint64) error {
+	s.io.RLock()
+	defer s.io.RUnlock()
+
+	var pos int64
+	for _, span := range Spans(s.files, offset, uint64(len(b))) {
+		h, err := s.open(span.Path, true)
+		if err != nil {
+			return err
+		}
+		_, err = h.f.WriteAt(b[pos:pos+span.Length], span.Offset)
+		s.release(h)
+		if err != nil {
+			return fmt.Errorf("failed writing to %s: %w", span.Path, err)
+		}
+		pos += span.Length
+	}
+	return nil
+}
+
+func (s *Storage) ReadAt(b []byte, offset uint64) error {
+	s.io.RLock()
+	defer s.io.RUnlock()
+
+	var pos int64
+	for _, span := range Spans(s.files, offset, uint64(len(b))) {
+		h, err := s.open(span.Path, false)
+		if err != nil {
+			return err
+		}
+		_, err = h.f.ReadAt(b[pos:pos+span.Length], span.Offset)
+		s.release(h)
+		if err != nil {
+			return fmt.Errorf("failed reading from %s: %w", span.Path, err)
+		}
+		pos += span.Length
+	}
+	return nil
+}
+
+func (s *Storage) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for path, h := range s.handles {
+		if !h.written {
+			continue
+		}
+		if err := h.f.Sync(); err != nil {
+			return fmt.Errorf("failed to sync %s: %w", path, err)
+		}
+		if h.refs == 0 {
+			h.written = false // a write in progress may not be part of the sync
+		}
+	}
+	return nil
+}
+
+// Close closes all open files, they are reopened when the storage is used again
+func (s *Storage) Close() error {
+	// exclusive, a handle may still be in use by a read or write holding io for reading
+	s.io.Lock()
+	defer s.io.Unlock()
+	return s.closeFiles()
+}
+
+// closeFiles closes every handle, the caller holds io for writing so none is in use
+func (s *Storage) closeFiles() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.evict(0)
+}
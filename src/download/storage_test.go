@@ -0,0 +1,58 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"torrent-client/src/parser"
+)
+
+// manyFiles makes a torrent of n one-byte files, each one a piece
+func manyFiles(n int) (*parser.Torrent, []byte) {
+	files := make([]parser.InfoFile, n)
+	data := make([]byte, n)
+	for i := range files {
+		files[i] = parser.InfoFile{Length: 1, Path: []string{fmt.Sprintf("f%d", i)}}
+		data[i] = byte(i)
+	}
+	return seedTorrent("many", files, data, 1), data
+}
+
+func TestStorageOpenFilesCapped(t *testing.T) {
+	torrent, data := manyFiles(MAX_OPEN_FILES + 50)
+	storage := NewStorage(torrent, t.TempDir())
+	defer storage.Close()
+
+	if err := storage.WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	if open := len(storage.handles); open > MAX_OPEN_FILES {
+		t.Errorf("%d files open, want at most %d", open, MAX_OPEN_FILES)
+	}
+
+	got := make([]byte, len(data))
+	if err := storage.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("read back different data")
+	}
+	if storage.lru.Len() != len(storage.handles) {
+		t.Errorf("%d handles in the LRU, %d in the map", storage.lru.Len(), len(storage.handles))
+	}
+}
+
+func TestStorageReadDoesNotCreate(t *testing.T) {
+	torrent, _ := manyFiles(2)
+	storage := NewStorage(torrent, t.TempDir())
+	defer storage.Close()
+
+	if err := storage.ReadAt(make([]byte, 1), 0); err == nil {
+		t.Error("reading a missing file succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(storage.BaseDir(), "f0")); !os.IsNotExist(err) {
+		t.Errorf("the read created the file: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package download
+
+import (
+	"runtime"
+	"sync"
+	"torrent-client/src/hashing"
+	"torrent-client/src/parser"
+)
+
+// Progress is called after every checked piece with the number of checked pieces so far
+type Progress func(checked uint32, total uint32)
+
+type verifyResult struct {
+	pieceIndex uint32
+	valid      bool
+}
+
+func AllPieces(t *parser.Torrent) []uint32 {
+	pieces := make([]uint32, t.Info.PieceCount)
+	for i := range pieces {
+		pieces[i] = uint32(i)
+	}
+	return pieces
+}
+
+/*
+Verify hashes the given pieces of the data found in outDir (piece files or
+assembled output files) and returns a bitfield with the pieces that match
+their hash in the torrent. Missing or short files simply leave their pieces
+unset.
+*/
+func Verify(t *parser.Torrent, outDir string, pieces []uint32, progress Progress) []byte {
+	baseDir := BaseDir(t, outDir)
+	files := Layout(t)
+	bitfield := make([]byte, (t.Info.PieceCount+7)/8)
+
+	jobs := make(chan uint32)
+	results := make(chan verifyResult)
+	var wg sync.WaitGroup
+
+	// readers hand the pieces to the shared hashing pool, so disk reads overlap with hashing
+	for range runtime.NumCPU() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pieceIndex := range jobs {
+				piece, err := ReadPiece(t, files, baseDir, pieceIndex)
+				valid := err == nil && CheckPiece(t, pieceIndex, hashing.Default.Hash(piece)) == nil
+				results <- verifyResult{pieceIndex, valid}
+			}
+		}()
+	}
+
+	go func() {
+		for _, pieceIndex := range pieces {
+			jobs <- pieceIndex
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	var checked uint32
+	total := uint32(len(pieces))
+	for res := range results {
+		if res.valid {
+			bitfield[res.pieceIndex/8] |= byte(1 << (7 - res.pieceIndex%8))
+		}
+		checked++
+		if progress != nil {
+			progress(checked, total)
+		}
+	}
+	return bitfield
+}
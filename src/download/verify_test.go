@@ -0,0 +1,116 @@
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"torrent-client/src/parser"
+)
+
+// setPieces lists the pieces set in a bitfield
+func setPieces(bitfield []byte, count uint32) []uint32 {
+	var pieces []uint32
+	for i := range count {
+		if bitfield[i/8]&(1<<(7-i%8)) != 0 {
+			pieces = append(pieces, i)
+		}
+	}
+	return pieces
+}
+
+func TestVerify(t *testing.T) {
+	// pieces of 8 bytes: 0 in a, 1 across a and b, 2 in b, 3 in c
+	data := []byte("aaaaaaaaaabbbbbbbbbbbbbbcccccccc")
+	files := []parser.InfoFile{
+		{Length: 10, Path: []string{"a"}},
+		{Length: 14, Path: []string{"sub", "b"}},
+		{Length: 8, Path: []string{"c"}},
+	}
+	torrent := seedTorrent("dir", files, data, 8)
+
+	tests := []struct {
+		name   string
+		change func(baseDir string) error // what happens to the data on disk
+		pieces []uint32                   // checked, nil for all of them
+		want   []uint32
+	}{
+		{name: "intact", want: []uint32{0, 1, 2, 3}},
+		{
+			name: "corrupt piece",
+			change: func(baseDir string) error {
+				return os.WriteFile(filepath.Join(baseDir, "sub", "b"), []byte("bbbbbbbbbXbbbb"), 0644)
+			},
+			want: []uint32{0, 1, 3},
+		},
+		{
+			name:   "missing last file",
+			change: func(baseDir string) error { return os.Remove(filepath.Join(baseDir, "c")) },
+			want:   []uint32{0, 1, 2},
+		},
+		{
+			name:   "missing file under two pieces",
+			change: func(baseDir string) error { return os.Remove(filepath.Join(baseDir, "a")) },
+			want:   []uint32{2, 3},
+		},
+		{
+			name:   "short file",
+			change: func(baseDir string) error { return os.Truncate(filepath.Join(baseDir, "sub", "b"), 6) },
+			want:   []uint32{0, 1, 3},
+		},
+		{
+			name:   "some pieces",
+			pieces: []uint32{1, 3},
+			want:   []uint32{1, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outDir := t.TempDir()
+			baseDir := BaseDir(torrent, outDir)
+			var pos uint64
+			for _, file := range files {
+				path := filepath.Join(append([]string{baseDir}, file.Path...)...)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, data[pos:pos+file.Length], 0644); err != nil {
+					t.Fatal(err)
+				}
+				pos += file.Length
+			}
+			if tt.change != nil {
+				if err := tt.change(baseDir); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pieces := tt.pieces
+			if pieces == nil {
+				pieces = AllPieces(torrent)
+			}
+
+			var calls []uint32
+			bitfield := Verify(torrent, outDir, pieces, func(checked, total uint32) {
+				if total != uint32(len(pieces)) {
+					t.Errorf("progress total %d, want %d", total, len(pieces))
+				}
+				calls = append(calls, checked)
+			})
+
+			if got := setPieces(bitfield, torrent.Info.PieceCount); !slices.Equal(got, tt.want) {
+				t.Errorf("valid pieces %v, want %v", got, tt.want)
+			}
+			if len(bitfield) != int(torrent.Info.PieceCount+7)/8 {
+				t.Errorf("bitfield of %d bytes for %d pieces", len(bitfield), torrent.Info.PieceCount)
+			}
+			for i, checked := range calls {
+				if checked != uint32(i+1) {
+					t.Fatalf("progress calls %v, want 1 to %d", calls, len(pieces))
+				}
+			}
+			if len(calls) != len(pieces) {
+				t.Errorf("progress called %d times, want once per piece (%d)", len(calls), len(pieces))
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"torrent-client/src/parser"
+	"torrent-client/src/peers"
+)
+
+/*
+WebSeed downloads pieces from an HTTP server holding the torrent's files
+(BEP 19 url-list). The url of a file is
+
+- single file torrents: the web seed url itself, or the url followed by the
+  torrent name when it ends with a slash
+- multi file torrents: the url, the torrent name and the file's path, one
+  path component after the other
+
+A piece is fetched with one Range request per file it lies in, so a piece
+spanning several files takes several requests. A 206 must carry the range
+that was asked for in its Content-Range, a server ignoring the range (200)
+has the part before it skipped. A 503 with a Retry-After header is returned
+as a *RetryError. The pieces are not checked here, they go through the same
+hash check as the pieces of peers.
+
+A server that takes longer than WEBSEED_HEADER_TIMEOUT to answer or stops
+sending for WEBSEED_READ_TIMEOUT in the middle of a response fails the
+request.
+*/
+
+const WEBSEED_HEADER_TIMEOUT = 30 * time.Second
+const WEBSEED_READ_TIMEOUT = 30 * time.Second
+
+type WebSeed struct {
+	url    string
+	client *http.Client
+	files  []LayoutFile // Path is the url of the file
+}
+
+// NewWebSeedClient returns an HTTP client for web seeds whose connections are passed through wrap (rate limits, statistics)
+func NewWebSeedClient(wrap func(net.Conn) net.Conn) *http.Client {
+	dialer := &net.Dialer{Timeout: WEBSEED_HEADER_TIMEOUT}
+	return &http.Client{Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return wrap(&peers.IdleConn{Conn: conn, Timeout: WEBSEED_READ_TIMEOUT}), nil
+		},
+		ResponseHeaderTimeout: WEBSEED_HEADER_TIMEOUT,
+	}}
+}
+
+// webSeedFileURLs lays the files out like Layout, with their urls on the web seed as paths
+func webSeedFileURLs(t *parser.Torrent, seed string) []LayoutFile {
+	if !t.HasMultipleFiles {
+		fileURL := seed
+		if strings.HasSuffix(seed, "/") {
+			fileURL += url.PathEscape(t.Info.Name)
+		}
+		return []LayoutFile{{Path: fileURL, Offset: 0, Length: t.TotalLength}}
+	}
+
+	base := strings.TrimSuffix(seed, "/") + "/" + url.PathEscape(t.Info.Name)
+	files := make([]LayoutFile, 0, len(t.Info.Files))
+	var offset uint64
+	for _, f := range t.Info.Files {
+		fileURL := base
+		for _, component := range f.Path {
+			fileURL += "/" + url.PathEscape(component)
+		}
+		files = append(files, LayoutFile{Path: fileURL, Offset: offset, Length: f.Length})
+		offset += f.Length
+	}
+	return files
+}
+
+func NewWebSeed(t *parser.Torrent, seed string, client *http.Client) *WebSeed {
+	return &WebSeed{url: seed, client: client, files: webSeedFileURLs(t, seed)}
+}
+
+func (w *WebSeed) URL() string {
+	return w.url
+}
+
+func (w *WebSeed) FetchPiece(t *parser.Torrent, pieceIndex uint32) ([]byte, error) {
+	piece := make([]byte, PieceLength(t, pieceIndex))
+	var pos int64
+	for _, span := range PieceSpans(t, w.files, pieceIndex) {
+		if err := w.fetchRange(span, piece[pos:pos+span.Length]); err != nil {
+			return nil, err
+		}
+		pos += span.Length
+	}
+	return piece, nil
+}
+
+// fetchRange reads the bytes of span into buf
+func (w *WebSeed) fetchRange(span FileSpan, buf []byte) error {
+	req, err := http.NewRequest(http.MethodGet, span.Path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", span.Offset, span.Offset+span.Length-1))
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		if err := checkContentRange(res.Header.Get("Content-Range"), span); err != nil {
+			return fmt.Errorf("%s: %w", span.Path, err)
+		}
+	case http.StatusOK:
+		// the server ignored the range and sends the whole file
+		if _, err := io.CopyN(io.Discard, res.Body, span.Offset); err != nil {
+			return fmt.Errorf("%s: %w", span.Path, err)
+		}
+	case http.StatusServiceUnavailable:
+		if after, ok := retryAfter(res); ok {
+			return newRetryError(after)
+		}
+		return fmt.Errorf("%s: %s", span.Path, res.Status)
+	default:
+		return fmt.Errorf("%s: %s", span.Path, res.Status)
+	}
+	if _, err := io.ReadFull(res.Body, buf); err != nil {
+		return fmt.Errorf("%s: %w", span.Path, err)
+	}
+	return nil
+}
+
+// checkContentRange makes sure the Content-Range of a 206 ("bytes first-last/size") is the range of span
+func checkContentRange(value string, span FileSpan) error {
+	rest, ok := strings.CutPrefix(value, "bytes ")
+	byteRange, _, ok2 := strings.Cut(rest, "/")
+	firstStr, lastStr, ok3 := strings.Cut(byteRange, "-")
+	first, err1 := strconv.ParseInt(firstStr, 10, 64)
+	last, err2 := strconv.ParseInt(lastStr, 10, 64)
+	if !ok || !ok2 || !ok3 || err1 != nil || err2 != nil {
+		return fmt.Errorf("invalid Content-Range %q", value)
+	}
+	if first != span.Offset || last != span.Offset+span.Length-1 {
+		return fmt.Errorf("got bytes %d-%d instead of %d-%d", first, last, span.Offset, span.Offset+span.Length-1)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"torrent-client/src/parser"
+)
+
+// seedTorrent makes a torrent of data split into files (a single file torrent when there is one file without a path)
+func seedTorrent(name string, files []parser.InfoFile, data []byte, pieceLength uint64) *parser.Torrent {
+	t := &parser.Torrent{TotalLength: uint64(len(data)), HasMultipleFiles: len(files) > 1 || len(files[0].Path) > 0}
+	t.Info.Name = name
+	t.Info.PieceLength = pieceLength
+	if t.HasMultipleFiles {
+		t.Info.Files = files
+	} else {
+		t.Info.Length = uint64(len(data))
+	}
+	for start := uint64(0); start < uint64(len(data)); start += pieceLength {
+		hash := sha1.Sum(data[start:min(start+pieceLength, uint64(len(data)))])
+		t.Info.PieceHashes = append(t.Info.PieceHashes, hash[:])
+	}
+	t.Info.PieceCount = uint32(len(t.Info.PieceHashes))
+	return t
+}
+
+// fileServer serves the files at their paths with Range support and records the Range header of every request
+type fileServer struct {
+	mu     sync.Mutex
+	files  map[string][]byte
+	ranges []string // "path range"
+}
+
+func (f *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.ranges = append(f.ranges, r.URL.Path+" "+r.Header.Get("Range"))
+	content, ok := f.files[r.URL.Path]
+	f.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+func testClient() *http.Client {
+	return NewWebSeedClient(func(conn net.Conn) net.Conn { return conn })
+}
+
+func fetchAll(t *testing.T, seed Seed, torrent *parser.Torrent) []byte {
+	t.Helper()
+	var all []byte
+	for i := range torrent.Info.PieceCount {
+		piece, err := seed.FetchPiece(torrent, i)
+		if err != nil {
+			t.Fatalf("piece %d: %v", i, err)
+		}
+		hash := sha1.Sum(piece)
+		if err := CheckPiece(torrent, i, hash[:]); err != nil {
+			t.Fatalf("piece %d: %v", i, err)
+		}
+		all = append(all, piece...)
+	}
+	return all
+}
+
+func TestWebSeedFileURLs(t *testing.T) {
+	single := seedTorrent("my file.iso", []parser.InfoFile{{Length: 4}}, make([]byte, 4), 4)
+	multi := seedTorrent("my dir", []parser.InfoFile{{Length: 2, Path: []string{"a"}}, {Length: 2, Path: []string{"sub dir", "b#1.txt"}}}, make([]byte, 4), 4)
+	tests := []struct {
+		name    string
+		torrent *parser.Torrent
+		seed    string
+		want    []string
+	}{
+		{"single file", single, "http://host/files/data.iso", []string{"http://host/files/data.iso"}},
+		{"single file in a directory", single, "http://host/files/", []string{"http://host/files/my%20file.iso"}},
+		{"multi file", multi, "http://host/seed", []string{"http://host/seed/my%20dir/a", "http://host/seed/my%20dir/sub%20dir/b%231.txt"}},
+		{"multi file with slash", multi, "http://host/seed/", []string{"http://host/seed/my%20dir/a", "http://host/seed/my%20dir/sub%20dir/b%231.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := webSeedFileURLs(tt.torrent, tt.seed)
+			if len(files) != len(tt.want) {
+				t.Fatalf("got %d files, want %d", len(files), len(tt.want))
+			}
+			for i, f := range files {
+				if f.Path != tt.want[i] {
+					t.Errorf("file %d: got %s, want %s", i, f.Path, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWebSeedSingleFile(t *testing.T) {
+	data := []byte(strings.Repeat("0123456789", 5))
+	server := &fileServer{files: map[string][]byte{"/data/my file.bin": data}}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	torrent := seedTorrent("my file.bin", []parser.InfoFile{{Length: uint64(len(data))}}, data, 16)
+	got := fetchAll(t, NewWebSeed(torrent, srv.URL+"/data/", testClient()), torrent)
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %q, want %q", got, data)
+	}
+	if last := server.ranges[len(server.ranges)-1]; last != "/data/my file.bin bytes=48-49" {
+		t.Errorf("last request %q", last)
+	}
+}
+
+// pieces spanning files take one range request per file
+func TestWebSeedAcrossFiles(t *testing.T) {
+	data := []byte("aaaaaaaaaabbbbbbbbbbbbbbbbbbbbbbbbbccccccc")
+	files := []parser.InfoFile{
+		{Length: 10, Path: []string{"a"}},
+		{Length: 25, Path: []string{"sub dir", "b"}},
+		{Length: 0, Path: []string{"empty"}},
+		{Length: 7, Path: []string{"c"}},
+	}
+	server := &fileServer{files: map[string][]byte{
+		"/seed/t/a":         data[:10],
+		"/seed/t/sub dir/b": data[10:35],
+		"/seed/t/c":         data[35:],
+	}}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	torrent := seedTorrent("t", files, data, 16)
+	got := fetchAll(t, NewWebSeed(torrent, srv.URL+"/seed", testClient()), torrent)
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %q, want %q", got, data)
+	}
+	want := []string{
+		"/seed/t/a bytes=0-9", "/seed/t/sub dir/b bytes=0-5", // piece 0
+		"/seed/t/sub dir/b bytes=6-21",                         // piece 1
+		"/seed/t/sub dir/b bytes=22-24", "/seed/t/c bytes=0-6", // piece 2
+	}
+	if strings.Join(server.ranges, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests\n%s\nwant\n%s", strings.Join(server.ranges, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// a server that ignores the range sends the whole file, the part before the range is skipped
+func TestWebSeedWithoutRangeSupport(t *testing.T) {
+	data := []byte(strings.Repeat("abcdefgh", 8))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	torrent := seedTorrent("f", []parser.InfoFile{{Length: uint64(len(data))}}, data, 24)
+	if got := fetchAll(t, NewWebSeed(torrent, srv.URL+"/f", testClient()), torrent); !bytes.Equal(got, data) {
+		t.Errorf("got %q, want %q", got, data)
+	}
+}
+
+func TestWebSeedErrors(t *testing.T) {
+	data := []byte(strings.Repeat("x", 32))
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		check   func(err error) bool
+	}{
+		{"not found", func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		}, func(err error) bool { return err != nil && strings.Contains(err.Error(), "404") }},
+		{"busy", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, func(err error) bool {
+			var retry *RetryError
+			return errors.As(err, &retry) && retry.After == 7*time.Second
+		}},
+		{"unavailable without retry", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, func(err error) bool {
+			var retry *RetryError
+			return err != nil && !errors.As(err, &retry)
+		}},
+		{"wrong range", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Range", "bytes 1-16/32")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[1:17])
+		}, func(err error) bool { return err != nil && strings.Contains(err.Error(), "instead of 0-15") }},
+		{"missing range", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[:16])
+		}, func(err error) bool { return err != nil && strings.Contains(err.Error(), "invalid Content-Range") }},
+		{"short body", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Range", "bytes 0-15/32")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[:10])
+		}, func(err error) bool { return err != nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			torrent := seedTorrent("f", []parser.InfoFile{{Length: uint64(len(data))}}, data, 16)
+			piece, err := NewWebSeed(torrent, srv.URL+"/f", testClient()).FetchPiece(torrent, 0)
+			if !tt.check(err) {
+				t.Errorf("unexpected result: %v (piece %q)", err, piece)
+			}
+		})
+	}
+}
+
+// the web seed hands out what the server sent, a corrupted piece is caught by the hash check
+func TestWebSeedBadPiece(t *testing.T) {
+	data := []byte(strings.Repeat("good", 8))
+	corrupted := bytes.Clone(data)
+	corrupted[20] ^= 0xff
+	srv := httptest.NewServer(&fileServer{files: map[string][]byte{"/f": corrupted}})
+	defer srv.Close()
+
+	torrent := seedTorrent("f", []parser.InfoFile{{Length: uint64(len(data))}}, data, 16)
+	seed := NewWebSeed(torrent, srv.URL+"/f", testClient())
+	for i, wantErr := range []bool{false, true} {
+		piece, err := seed.FetchPiece(torrent, uint32(i))
+		if err != nil {
+			t.Fatalf("piece %d: %v", i, err)
+		}
+		hash := sha1.Sum(piece)
+		if err := CheckPiece(torrent, uint32(i), hash[:]); (err != nil) != wantErr {
+			t.Errorf("piece %d: hash check returned %v", i, err)
+		}
+	}
+}
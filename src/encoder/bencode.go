@@ -0,0 +1,57 @@
+package encoder
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+Bencode writers, shared by the torrent files made here and the responses of
+the tracker. Each returns the encoded value, so containers are built from
+already encoded elements.
+*/
+
+// BencodeString writes a byte string
+func BencodeString(value string) string {
+	return fmt.Sprintf("%d:%s", len(value), value)
+}
+
+// BencodeInt writes an integer
+func BencodeInt(value uint64) string {
+	return fmt.Sprintf("i%de", value)
+}
+
+// BencodeBytes writes raw bytes as a byte string
+func BencodeBytes(b []byte) string {
+	return fmt.Sprintf("%d:%s", len(b), string(b))
+}
+
+// BencodeStringList writes a list of byte strings
+func BencodeStringList(values []string) string {
+	var out strings.Builder
+	out.WriteString("l")
+	for _, value := range values {
+		out.WriteString(BencodeString(value))
+	}
+	out.WriteString("e")
+	return out.String()
+}
+
+// BencodeDict writes a dictionary of encoded values with its keys sorted
+func BencodeDict(values map[string]string) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var out strings.Builder
+	out.WriteString("d")
+	for _, key := range keys {
+		out.WriteString(BencodeString(key))
+		out.WriteString(values[key])
+	}
+	out.WriteString("e")
+	return out.String()
+}
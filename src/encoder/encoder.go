@@ -2,22 +2,49 @@ package encoder
 
 import (
 	"bufio"
-	"crypto/sha1"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"os/user"
 	"path/filepath"
-	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
+	"torrent-client/src/hashing"
 )
 
+/*
+Create makes a torrent file from a file or a directory, Interactive asks for
+the same details on stdin.
+
+Every dictionary is written with its keys sorted, as bencode requires. The
+source and private keys go into the info dictionary, so they change the info
+hash: a torrent made with a source for each tracker can be cross-seeded from
+the same data without the trackers seeing the same torrent.
+*/
+
+const MIN_PIECE_LENGTH = 16 * 1024
+const MAX_PIECE_LENGTH = 16 * 1024 * 1024
+const TARGET_PIECES = 1500 // pieces aimed for when the piece length is picked from the size
+
+type Options struct {
+	Path        string     // file or directory to share
+	Name        string     // name of the torrent, the base name of Path when empty
+	Trackers    [][]string // tiers of announce urls (BEP 12), the first url becomes the announce
+	WebSeeds    []string   // url-list (BEP 19)
+	Comment     string
+	CreatedBy   string
+	Private     bool   // BEP 27
+	Source      string // info dictionary "source"
+	PieceLength uint64 // a power of two, 0 picks one from the total size
+	Output      string // torrent file to write, or a directory to write <name>.torrent into; the working directory when empty
+}
+
 type File struct {
 	length uint64
-	path   []string
+	path   []string // relative to the shared directory
 }
 
 type Info struct {
@@ -26,12 +53,15 @@ type Info struct {
 	pieces      []byte
 	length      uint64
 	files       []File
+	private     bool
+	source      string
 }
 
 type Torrent struct {
 	name             string
 	announce         string
-	announceList     []string
+	announceList     [][]string
+	urlList          []string
 	createdBy        string
 	creationDate     int64
 	encoding         string
@@ -40,332 +70,377 @@ type Torrent struct {
 	info             Info
 }
 
-// --- Bencode Helpers ---
-
-func bencodeString(value string) string {
-	return fmt.Sprintf("%d:%s", len(value), value)
-}
-
-func bencodeInt(value uint64) string {
-	return fmt.Sprintf("i%de", value)
-}
-
-func bencodeBytes(b []byte) string {
-	return fmt.Sprintf("%d:%s", len(b), string(b))
-}
-
 func bencodeFileList(files []File) string {
 	var out strings.Builder
 	out.WriteString("l")
 	for _, f := range files {
-		out.WriteString("d")
-		out.WriteString(bencodeString("length"))
-		out.WriteString(bencodeInt(f.length))
-
-		out.WriteString(bencodeString("path"))
-		out.WriteString("l")
-		for _, part := range f.path {
-			out.WriteString(bencodeString(part))
-		}
-		out.WriteString("e") // end path list
-		out.WriteString("e") // end file dict
+		out.WriteString(BencodeDict(map[string]string{
+			"length": BencodeInt(f.length),
+			"path":   BencodeStringList(f.path),
+		}))
 	}
 	out.WriteString("e") // end file list
 	return out.String()
 }
 
 func bencodeInfo(info Info, hasMultipleFiles bool) string {
-	var out strings.Builder
-	out.WriteString("d")
-
-	// Sorted keys
-	out.WriteString(bencodeString("name"))
-	out.WriteString(bencodeString(info.name))
-
-	out.WriteString(bencodeString("piece length"))
-	out.WriteString(bencodeInt(info.pieceLength))
-
+	values := map[string]string{
+		"name":         BencodeString(info.name),
+		"piece length": BencodeInt(info.pieceLength),
+		"pieces":       BencodeBytes(info.pieces),
+	}
 	if hasMultipleFiles {
-		out.WriteString(bencodeString("files"))
-		out.WriteString(bencodeFileList(info.files))
+		values["files"] = bencodeFileList(info.files)
 	} else {
-		out.WriteString(bencodeString("length"))
-		out.WriteString(bencodeInt(info.length))
+		values["length"] = BencodeInt(info.length)
 	}
+	if info.private {
+		values["private"] = BencodeInt(1)
+	}
+	if info.source != "" {
+		values["source"] = BencodeString(info.source)
+	}
+	return BencodeDict(values)
+}
 
-	out.WriteString(bencodeString("pieces"))
-	out.WriteString(bencodeBytes(info.pieces))
-
-	out.WriteString("e") // end info dict
-	return out.String()
+func bencodeTorrent(meta Torrent) string {
+	values := map[string]string{
+		"creation date": BencodeInt(uint64(meta.creationDate)),
+		"encoding":      BencodeString(meta.encoding),
+		"info":          bencodeInfo(meta.info, meta.hasMultipleFiles),
+	}
+	if meta.announce != "" {
+		values["announce"] = BencodeString(meta.announce)
+	}
+	if len(meta.announceList) > 0 {
+		var tiers strings.Builder
+		tiers.WriteString("l")
+		for _, tier := range meta.announceList {
+			tiers.WriteString(BencodeStringList(tier))
+		}
+		tiers.WriteString("e")
+		values["announce-list"] = tiers.String()
+	}
+	if len(meta.urlList) > 0 {
+		values["url-list"] = BencodeStringList(meta.urlList)
+	}
+	if meta.createdBy != "" {
+		values["created by"] = BencodeString(meta.createdBy)
+	}
+	if meta.comment != "" {
+		values["comment"] = BencodeString(meta.comment)
+	}
+	return BencodeDict(values)
 }
 
 // --- Torrent Helpers ---
 
-func getSHA1Sum(piece []byte) []byte {
-	hash := sha1.Sum(piece)
-	return hash[:]
+// filesReader reads files back to back, opening each one when it is reached and closing it at its end
+type filesReader struct {
+	root    string
+	files   []File // the ones not opened yet
+	current *os.File
+	limited io.Reader
 }
 
-func createPath(path string) []string {
-	delimeter := regexp.MustCompile(`[\\/|]+`)
-	return delimeter.Split(path, -1)
+func (r *filesReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.files) == 0 {
+				return 0, io.EOF
+			}
+			path := filepath.Join(append([]string{r.root}, r.files[0].path...)...)
+			f, err := os.Open(path)
+			if err != nil {
+				return 0, fmt.Errorf("failed to open file [%s]: %w", path, err)
+			}
+			r.current = f
+			r.limited = io.LimitReader(f, int64(r.files[0].length))
+			r.files = r.files[1:]
+		}
+		n, err := r.limited.Read(p)
+		if err == io.EOF {
+			// a file that got shorter ends early too, the caller compares the total length
+			r.Close()
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
 }
 
-func encryptFiles(files []File, pieceLength uint64) []byte {
-	var pieces []byte
-	var buffer []byte
-
-	for _, file := range files {
-		path := strings.Join(file.path, "/")
-		f, err := os.Open(path)
-		if err != nil {
-			fmt.Printf("Failed to open file [%s]: %e\n", path, err)
-			os.Exit(-1)
-		}
-		defer f.Close()
+// Close closes the file being read, if any
+func (r *filesReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+	return err
+}
 
-		tmp := make([]byte, file.length)
-		_, err = f.Read(tmp)
-		if err != nil && err != io.EOF {
-			fmt.Printf("Error reading file [%s]: %e\n", path, err)
-			os.Exit(-1)
+/*
+encryptFiles streams the files under root back to back in pieceLength chunks
+and hands every chunk to the shared hashing pool, collecting the hashes in
+order. At most a couple of pieces per core are in memory at any time, and
+only one file is open.
+*/
+func encryptFiles(root string, files []File, pieceLength uint64) ([]byte, error) {
+	reader := &filesReader{root: root, files: files}
+	defer reader.Close()
+
+	hashes := make(chan (<-chan []byte), 2*runtime.NumCPU())
+	var readErr error
+	var read uint64
+	go func() {
+		defer close(hashes)
+		for {
+			buffer := make([]byte, pieceLength)
+			n, err := io.ReadFull(reader, buffer)
+			read += uint64(n)
+			if n > 0 {
+				hashes <- hashing.Default.Submit(buffer[:n])
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			} else if err != nil {
+				readErr = fmt.Errorf("error reading files: %w", err)
+				return
+			}
 		}
+	}()
 
-		buffer = append(buffer, tmp...)
+	var pieces []byte
+	for hash := range hashes {
+		pieces = append(pieces, <-hash...)
 	}
-
-	for i := uint64(0); i+pieceLength <= uint64(len(buffer)); i += pieceLength {
-		piece := getSHA1Sum(buffer[i : i+pieceLength])
-		pieces = append(pieces, piece...)
+	if readErr != nil {
+		return nil, readErr
 	}
-
-	// Handle final piece (if not aligned)
-	if rem := uint64(len(buffer)) % pieceLength; rem != 0 {
-		start := uint64(len(buffer)) - rem
-		piece := getSHA1Sum(buffer[start:])
-		pieces = append(pieces, piece...)
+	var length uint64
+	for _, file := range files {
+		length += file.length
 	}
-
-	return pieces
+	if read != length {
+		return nil, fmt.Errorf("files got shorter while hashing them")
+	}
+	return pieces, nil
 }
 
-func traverseDirectory(path string) []File {
+// traverseDirectory lists the files under root, their paths relative to it
+func traverseDirectory(root string) ([]File, error) {
 	var paths []File
-	err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 		info, err := os.Stat(path)
 		if err != nil {
-			fmt.Printf("Failed to stat [%s]: %e\n", path, err)
-			os.Exit(-1)
+			return fmt.Errorf("failed to stat [%s]: %w", path, err)
+		}
+		if info.IsDir() {
+			return nil
 		}
-		if !info.IsDir() {
-			paths = append(paths, File{uint64(info.Size()), createPath(path)})
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
 		}
+		paths = append(paths, File{uint64(info.Size()), strings.Split(filepath.ToSlash(rel), "/")})
 		return nil
 	})
 	if err != nil {
-		fmt.Println("Directory walk failed:", err)
+		return nil, fmt.Errorf("directory walk failed: %w", err)
 	}
-	return paths
+	return paths, nil
 }
 
-func getPath(pieceLength uint64) ([]File, []byte) {
-	var path string
-	var info os.FileInfo
-	reader := bufio.NewReader(os.Stdin)
+// defaultPieceLength aims for TARGET_PIECES pieces, rounded up to a power of two
+func defaultPieceLength(total uint64) uint64 {
+	length := uint64(MIN_PIECE_LENGTH)
+	for length < MAX_PIECE_LENGTH && length*TARGET_PIECES < total {
+		length *= 2
+	}
+	return length
+}
 
-	for {
-		fmt.Print("Path to the file or directory [required]: ")
-		input, _ := reader.ReadString('\n')
-		path = strings.TrimSpace(input)
-		if path == "" {
-			continue
-		}
+// outputPath is where the torrent file goes, see Options.Output
+func outputPath(output string, name string) string {
+	if output == "" {
+		return name + ".torrent"
+	}
+	if info, err := os.Stat(output); err == nil && info.IsDir() {
+		return filepath.Join(output, name+".torrent")
+	}
+	return output
+}
 
-		var err error
-		info, err = os.Stat(path)
-		if os.IsNotExist(err) {
-			fmt.Println("Path does not exist.")
+// Create hashes the data at opts.Path and writes the torrent file, returning its path
+func Create(opts Options) (string, error) {
+	info, err := os.Stat(opts.Path)
+	if err != nil {
+		return "", err
+	}
+	if opts.PieceLength != 0 && (opts.PieceLength < MIN_PIECE_LENGTH || opts.PieceLength&(opts.PieceLength-1) != 0) {
+		return "", fmt.Errorf("piece length %d is not a power of two of at least %d", opts.PieceLength, MIN_PIECE_LENGTH)
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = filepath.Base(filepath.Clean(opts.Path))
+	}
+	meta := Torrent{
+		name:         name,
+		urlList:      opts.WebSeeds,
+		createdBy:    opts.CreatedBy,
+		creationDate: time.Now().Unix(),
+		encoding:     "UTF-8",
+		comment:      opts.Comment,
+		info:         Info{name: name, private: opts.Private, source: opts.Source},
+	}
+	for _, urls := range opts.Trackers {
+		// "a, b," from the command line has blanks around the urls and an empty one
+		var tier []string
+		for _, url := range urls {
+			if url = strings.TrimSpace(url); url != "" {
+				tier = append(tier, url)
+			}
+		}
+		if len(tier) == 0 {
 			continue
-		} else if err != nil {
-			fmt.Println("Error:", err)
-			os.Exit(-1)
 		}
-		break
+		if meta.announce == "" {
+			meta.announce = tier[0]
+		}
+		meta.announceList = append(meta.announceList, tier)
+	}
+	// a single tracker needs no announce-list
+	if len(meta.announceList) == 1 && len(meta.announceList[0]) == 1 {
+		meta.announceList = nil
 	}
 
+	root := opts.Path
+	var files []File
 	if info.IsDir() {
-		files := traverseDirectory(path)
-		pieces := encryptFiles(files, pieceLength)
-		return files, pieces
+		if files, err = traverseDirectory(root); err != nil {
+			return "", err
+		}
+		if len(files) == 0 {
+			return "", fmt.Errorf("%s has no files", opts.Path)
+		}
+		meta.hasMultipleFiles = true
+		meta.info.files = files
 	} else {
-		file := []File{{uint64(info.Size()), createPath(path)}}
-		pieces := encryptFiles(file, pieceLength)
-		return file, pieces
+		root = filepath.Dir(opts.Path)
+		files = []File{{uint64(info.Size()), []string{filepath.Base(opts.Path)}}}
+		meta.info.length = files[0].length
 	}
-}
 
-func getDetails() Torrent {
-	currentUser, err := user.Current()
-	if err != nil {
-		fmt.Println("Couldn't get the username.")
-		currentUser.Name = "User Not Found"
-	}
-
-	var meta = Torrent{
-		name:             "",
-		announce:         "udp://tracker.openbittorrent.com:80/announce",
-		announceList:     []string{},
-		createdBy:        currentUser.Name,
-		creationDate:     time.Now().Unix(),
-		encoding:         "UTF-8",
-		comment:          "",
-		hasMultipleFiles: false,
-		info: Info{
-			name:        "",
-			pieceLength: 16 * 1024,
-			pieces:      []byte{},
-			length:      0,
-			files:       []File{},
-		},
+	var total uint64
+	for _, f := range files {
+		total += f.length
 	}
-
-	reader := bufio.NewReader(os.Stdin)
-
-	fmt.Printf("Announce URL [default: %s]: ", meta.announce)
-	fmt.Scanln(&meta.announce)
-
-	fmt.Println("Announce List [default: []]: ")
-	for {
-		var input string
-		fmt.Scanln(&input)
-
-		if input == "" {
-			break
-		}
-		meta.announceList = append(meta.announceList, input)
+	meta.info.pieceLength = opts.PieceLength
+	if meta.info.pieceLength == 0 {
+		meta.info.pieceLength = defaultPieceLength(total)
+	}
+	if meta.info.pieces, err = encryptFiles(root, files, meta.info.pieceLength); err != nil {
+		return "", err
 	}
 
-	fmt.Printf("Created by [default: %s]: ", meta.createdBy)
-	meta.createdBy, _ = reader.ReadString('\n')
-	meta.createdBy = strings.TrimSpace(meta.createdBy)
+	path := outputPath(opts.Output, name)
+	if err := os.WriteFile(path, []byte(bencodeTorrent(meta)), 0644); err != nil {
+		return "", fmt.Errorf("failed to write torrent file: %w", err)
+	}
+	return path, nil
+}
 
-	fmt.Print("Comment [default: \"\"]: ")
-	meta.comment, _ = reader.ReadString('\n')
-	meta.comment = strings.TrimSpace(meta.comment)
+// --- Interactive ---
 
+func getPath(reader *bufio.Reader) string {
 	for {
-		fmt.Print("Name of the file [required]: ")
+		fmt.Print("Path to the file or directory [required]: ")
 		input, err := reader.ReadString('\n')
-		if err != nil {
-			fmt.Println("Error reading input: ", err)
+		path := strings.TrimSpace(input)
+		if path == "" {
+			if err != nil {
+				return ""
+			}
 			continue
 		}
-		meta.name = strings.TrimSpace(input)
 
-		if meta.name != "" {
-			break
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			fmt.Println("Path does not exist.")
+			continue
 		}
+		return path
 	}
+}
 
-	fmt.Printf("Piece Size [default: %dKB]: ", meta.info.pieceLength/1024)
-	inp, err := reader.ReadString('\n')
-
-	if err != nil {
-		fmt.Println("Error reading input, using default:", err)
-	}
-
-	inp = strings.TrimSpace(inp)
-	if inp != "" {
-		uInp, err := strconv.Atoi(inp)
-		if err != nil {
-			fmt.Println("Invalid number, using default:", meta.info.pieceLength)
-		} else {
-			meta.info.pieceLength = uint64(uInp) * 1024
-		}
+func getDetails() Options {
+	opts := Options{
+		Trackers: [][]string{{"udp://tracker.openbittorrent.com:80/announce"}},
 	}
-
-	files, pieces := getPath(meta.info.pieceLength)
-	switch len(files) {
-	case 1:
-		meta.info.length = files[0].length
-		meta.info.pieces = pieces
-		meta.info.name = files[0].path[len(files[0].path)-1]
-	default:
-		meta.hasMultipleFiles = true
-		meta.info.name = meta.name
-		meta.info.files = files
-		meta.info.pieces = pieces
+	if currentUser, err := user.Current(); err != nil {
+		fmt.Println("Couldn't get the username.")
+	} else {
+		opts.CreatedBy = currentUser.Name
 	}
 
-	return meta
-}
-
-func createTorrent() {
-	meta := getDetails()
-
 	reader := bufio.NewReader(os.Stdin)
-	fmt.Print("Output path [default: ./]: ")
-	input, _ := reader.ReadString('\n')
-	input = strings.TrimSpace(input)
-	if input == "" {
-		input = "./"
+	readLine := func() string {
+		input, _ := reader.ReadString('\n')
+		return strings.TrimSpace(input)
 	}
 
-	var out strings.Builder
-	out.WriteString("d")
-	out.WriteString(bencodeString("announce"))
-	out.WriteString(bencodeString(meta.announce))
+	fmt.Printf("Announce URL [default: %s]: ", opts.Trackers[0][0])
+	if input := readLine(); input != "" {
+		opts.Trackers[0][0] = input
+	}
 
-	if len(meta.announceList) > 0 {
-		out.WriteString(bencodeString("announce-list"))
-		out.WriteString("l")
-		for _, tracker := range meta.announceList {
-			out.WriteString("l")
-			out.WriteString(bencodeString(tracker))
-			out.WriteString("e")
+	fmt.Println("Announce List, one tracker per line, empty line to end [default: []]: ")
+	for {
+		input := readLine()
+		if input == "" {
+			break
 		}
-		out.WriteString("e")
+		opts.Trackers = append(opts.Trackers, []string{input})
 	}
 
-	out.WriteString(bencodeString("created by"))
-	out.WriteString(bencodeString(meta.createdBy))
-
-	out.WriteString(bencodeString("creation date"))
-	out.WriteString(bencodeInt(uint64(meta.creationDate)))
-
-	out.WriteString(bencodeString("encoding"))
-	out.WriteString(bencodeString(meta.encoding))
-
-	if meta.comment != "" {
-		out.WriteString(bencodeString("comment"))
-		out.WriteString(bencodeString(meta.comment))
+	fmt.Printf("Created by [default: %s]: ", opts.CreatedBy)
+	if input := readLine(); input != "" {
+		opts.CreatedBy = input
 	}
 
-	out.WriteString(bencodeString("info"))
-	out.WriteString(bencodeInfo(meta.info, meta.hasMultipleFiles))
-	out.WriteString("e") // end root dict
+	fmt.Print("Comment [default: \"\"]: ")
+	opts.Comment = readLine()
 
-	outputPath := filepath.Join(input, meta.name+".torrent")
-	file, err := os.Create(outputPath)
-	if err != nil {
-		fmt.Println("Failed to create torrent file:", err)
-		os.Exit(-1)
-	}
-	defer file.Close()
+	fmt.Print("Name of the torrent [default: name of the file or directory]: ")
+	opts.Name = readLine()
 
-	_, err = file.WriteString(out.String())
-	if err != nil {
-		fmt.Println("Failed to write torrent file:", err)
-		os.Exit(-1)
+	fmt.Print("Piece Size in KB [default: picked from the size]: ")
+	if input := readLine(); input != "" {
+		kb, err := strconv.Atoi(input)
+		if err != nil || kb <= 0 {
+			fmt.Println("Invalid number, using default")
+		} else {
+			opts.PieceLength = uint64(kb) * 1024
+		}
 	}
 
-	fmt.Println("Torrent created at:", outputPath)
+	opts.Path = getPath(reader)
+
+	fmt.Print("Output path [default: ./]: ")
+	opts.Output = readLine()
+	return opts
 }
 
-func main() {
-	createTorrent()
+// Interactive asks for the details of the torrent on stdin and creates it
+func Interactive() (string, error) {
+	opts := getDetails()
+	if opts.Path == "" {
+		return "", fmt.Errorf("no path given")
+	}
+	return Create(opts)
 }
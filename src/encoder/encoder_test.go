@@ -0,0 +1,178 @@
+package encoder
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"torrent-client/src/download"
+	"torrent-client/src/parser"
+)
+
+// shareDir writes three files of 170005 bytes in all under dir/share
+func shareDir(t *testing.T, dir string) (string, []byte) {
+	t.Helper()
+	files := []struct {
+		path string
+		size int
+	}{
+		{"a.bin", 50000},
+		{filepath.Join("sub", "b.bin"), 120000},
+		{filepath.Join("sub", "c.txt"), 5},
+	}
+	root := filepath.Join(dir, "share")
+	var all []byte
+	for i, f := range files {
+		data := bytes.Repeat([]byte{byte('a' + i)}, f.size)
+		data[0] = byte(i) // no two pieces alike
+		path := filepath.Join(root, f.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, data...)
+	}
+	return root, all
+}
+
+func createTorrent(t *testing.T, opts Options) (*parser.Torrent, []byte) {
+	t.Helper()
+	path, err := Create(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrent, err := parser.DecodeTorrent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return torrent, raw
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	root, data := shareDir(t, dir)
+	const pieceLength = 16 * 1024
+
+	torrent, raw := createTorrent(t, Options{
+		Path:        root,
+		Trackers:    [][]string{{"http://a.example/announce", "http://b.example/announce"}, {"udp://c.example:6969/announce"}},
+		WebSeeds:    []string{"http://seed.example/"},
+		Comment:     "test data",
+		CreatedBy:   "tests",
+		Private:     true,
+		Source:      "SRC",
+		PieceLength: pieceLength,
+		Output:      dir,
+	})
+
+	if _, err := os.Stat(filepath.Join(dir, "share.torrent")); err != nil || torrent.Info.Name != "share" {
+		t.Errorf("name %q, torrent file: %v", torrent.Info.Name, err)
+	}
+	if torrent.Announce != "http://a.example/announce" {
+		t.Errorf("announce %q, want the first url of the first tier", torrent.Announce)
+	}
+	wantList := []string{"http://a.example/announce", "http://b.example/announce", "udp://c.example:6969/announce"}
+	if !slices.Equal(torrent.AnnounceList, wantList) {
+		t.Errorf("announce list %v, want %v", torrent.AnnounceList, wantList)
+	}
+	if !slices.Equal(torrent.UrlList, []string{"http://seed.example/"}) || torrent.Comment != "test data" || torrent.CreatedBy != "tests" {
+		t.Errorf("url list %v, comment %q, created by %q", torrent.UrlList, torrent.Comment, torrent.CreatedBy)
+	}
+	if !torrent.Info.Private {
+		t.Error("the torrent is not private")
+	}
+	if !bytes.Contains(raw, []byte("6:source3:SRC")) {
+		t.Error("the source is missing")
+	}
+
+	if !torrent.HasMultipleFiles || len(torrent.Info.Files) != 3 || torrent.TotalLength != uint64(len(data)) {
+		t.Fatalf("%d files of %d bytes", len(torrent.Info.Files), torrent.TotalLength)
+	}
+	if torrent.Info.PieceLength != pieceLength || torrent.Info.PieceCount != 11 {
+		t.Fatalf("%d pieces of %d bytes, want 11 of %d", torrent.Info.PieceCount, torrent.Info.PieceLength, pieceLength)
+	}
+	for i, hash := range torrent.Info.PieceHashes {
+		want := sha1.Sum(data[i*pieceLength : min((i+1)*pieceLength, len(data))])
+		if !bytes.Equal(hash, want[:]) {
+			t.Errorf("piece %d has the wrong hash", i)
+		}
+	}
+
+	// what a verify of the shared data finds
+	bitfield := download.Verify(torrent, dir, download.AllPieces(torrent), nil)
+	if !bytes.Equal(bitfield, []byte{0xff, 0xe0}) {
+		t.Errorf("verify found bitfield %08b, want all 11 pieces", bitfield)
+	}
+}
+
+func TestCreateSourceChangesInfoHash(t *testing.T) {
+	dir := t.TempDir()
+	root, _ := shareDir(t, dir)
+
+	a, _ := createTorrent(t, Options{Path: root, Source: "A", Output: filepath.Join(dir, "a.torrent")})
+	b, _ := createTorrent(t, Options{Path: root, Source: "B", Output: filepath.Join(dir, "b.torrent")})
+	if bytes.Equal(a.InfoHash, b.InfoHash) {
+		t.Error("torrents with different sources have the same info hash")
+	}
+}
+
+func TestCreateRejectsBadPieceLength(t *testing.T) {
+	dir := t.TempDir()
+	root, _ := shareDir(t, dir)
+	for _, length := range []uint64{1024, 3 * 16 * 1024} {
+		if _, err := Create(Options{Path: root, PieceLength: length, Output: dir}); err == nil {
+			t.Errorf("piece length %d accepted", length)
+		}
+	}
+}
+
+func TestCreateTrimsTrackers(t *testing.T) {
+	dir := t.TempDir()
+	root, _ := shareDir(t, dir)
+	tests := []struct {
+		name     string
+		trackers [][]string // as the -tracker flag splits them
+		announce string
+		list     []string
+	}{
+		{
+			name:     "blanks around the urls",
+			trackers: [][]string{strings.Split(" http://a.example/announce , http://b.example/announce", ",")},
+			announce: "http://a.example/announce",
+			list:     []string{"http://a.example/announce", "http://b.example/announce"},
+		},
+		{
+			name:     "empty urls",
+			trackers: [][]string{strings.Split("http://a.example/announce,,", ","), strings.Split(" ,udp://c.example:6969/announce", ",")},
+			announce: "http://a.example/announce",
+			list:     []string{"http://a.example/announce", "udp://c.example:6969/announce"},
+		},
+		{
+			name:     "empty first tier",
+			trackers: [][]string{strings.Split(" , ", ","), {"udp://c.example:6969/announce"}},
+			announce: "udp://c.example:6969/announce",
+		},
+		{name: "no urls", trackers: [][]string{{""}, {" "}}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			torrent, _ := createTorrent(t, Options{Path: root, Trackers: tt.trackers, Output: filepath.Join(dir, fmt.Sprintf("%d.torrent", i))})
+			if torrent.Announce != tt.announce {
+				t.Errorf("announce %q, want %q", torrent.Announce, tt.announce)
+			}
+			if !slices.Equal(torrent.AnnounceList, tt.list) {
+				t.Errorf("announce list %q, want %q", torrent.AnnounceList, tt.list)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package hashing
+
+import (
+	"crypto/sha1"
+	"runtime"
+)
+
+/*
+Pool hashes pieces on a fixed number of worker goroutines. Work is queued on
+a bounded channel, so Submit blocks once the workers fall behind instead of
+piling up pieces in memory. That holds for file readers and for the peer and
+web seed goroutines alike: a producer that has to wait stops fetching more
+pieces until the workers catch up.
+*/
+
+type job struct {
+	data   []byte
+	result chan []byte
+}
+
+type Pool struct {
+	jobs chan job
+}
+
+// Default is the pool shared by the downloader, the recheck and the torrent creator
+var Default = NewPool(runtime.NumCPU(), 2*runtime.NumCPU())
+
+func NewPool(workers int, queueSize int) *Pool {
+	p := &Pool{jobs: make(chan job, queueSize)}
+	for range workers {
+		go p.work()
+	}
+	return p
+}
+
+func (p *Pool) work() {
+	for j := range p.jobs {
+		hash := sha1.Sum(j.data)
+		j.result <- hash[:]
+	}
+}
+
+// Submit queues data for hashing and returns a channel that receives its SHA-1 hash
+func (p *Pool) Submit(data []byte) <-chan []byte {
+	result := make(chan []byte, 1)
+	p.jobs <- job{data: data, result: result}
+	return result
+}
+
+func (p *Pool) Hash(data []byte) []byte {
+	return <-p.Submit(data)
+}
@@ -1,22 +1,37 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	// "io"
+	"net"
+	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+	"torrent-client/src/blocklist"
 	"torrent-client/src/download"
+	"torrent-client/src/hashing"
 	"torrent-client/src/parser"
 	"torrent-client/src/peers"
+	"torrent-client/src/ratelimit"
+	"torrent-client/src/resume"
+	"torrent-client/src/stats"
 	"torrent-client/src/utils"
 )
 
 const (
 	CONCURRENT_DONWLOADS = 5
 	CONCURRENT_UPLOADS   = 4
+	MAX_HALF_OPEN        = 3
+	WEBSEED_MAX_FAILURES = 5
+	WEBSEED_RETRY        = 30 * time.Second // after a failed request, times the failures in a row
+	WEBSEED_IDLE         = 10 * time.Second // before looking again when every missing piece is being downloaded
 )
 
 func check(path string, outDir string) {
@@ -37,6 +52,21 @@ func check(path string, outDir string) {
 	}
 }
 
+func readTorrent(path string) *parser.Torrent {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Read: ", err)
+		os.Exit(1)
+	}
+
+	t, err := parser.DecodeTorrent(data)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error while reading torrent file: ", err)
+		os.Exit(1)
+	}
+	return t
+}
+
 func getDownloadedLen(pieceCount uint32) uint32 {
 	len := pieceCount / 8
 	if pieceCount%8 != 0 {
@@ -45,24 +75,88 @@ func getDownloadedLen(pieceCount uint32) uint32 {
 	return len
 }
 
-func GetPeers(t *parser.Torrent) (*parser.Response, error) {
-	res, err := peers.RequestTracker(t, t.Announce)
-	if err == nil {
-		return res, nil
-	} else {
+/*
+loadResume restores the pieces that were verified in a previous run and whose
+files on disk are unchanged since. Without usable resume data, or for pieces
+whose files were touched, the data already on disk is rechecked instead.
+*/
+func loadResume(t *parser.Torrent, outDir string, downloaded *utils.Downloaded, st *stats.Torrent, known *utils.AvailablePeers) {
+	baseDir := download.BaseDir(t, outDir)
+	recheck := download.AllPieces(t)
+	bitfield := make([]byte, getDownloadedLen(t.Info.PieceCount))
+
+	rd, err := resume.Load(resume.FilePath(outDir, t.InfoHash))
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintln(os.Stderr, "Ignoring resume data:", err)
+	} else if err == nil && !rd.Matches(t.InfoHash) {
+		fmt.Fprintln(os.Stderr, "Ignoring resume data: info hash mismatch")
+	} else if err == nil && len(rd.Bitfield) != len(bitfield) {
+		fmt.Fprintf(os.Stderr, "Ignoring resume data: bitfield of %d bytes, expected %d\n", len(rd.Bitfield), len(bitfield))
+	} else if err == nil {
+		bitfield = rd.Restore(t.Info.PieceCount, baseDir, download.PieceFiles(t, baseDir))
+		st.SetPrevious(rd.Downloaded, rd.Uploaded)
+		for _, peer := range rd.CachedPeers() {
+			known.Add(peer)
+		}
+
+		// only the pieces that were complete but whose files changed need a recheck
+		recheck = nil
+		for i := range t.Info.PieceCount {
+			bit := byte(1 << (7 - i%8))
+			if rd.Bitfield[i/8]&bit != 0 && bitfield[i/8]&bit == 0 {
+				recheck = append(recheck, i)
+			}
+		}
 	}
 
-	for _, url := range t.AnnounceList {
-		err = nil
-		res, err = peers.RequestTracker(t, url)
-		if err == nil {
-			return res, nil
-		} else {
-			fmt.Printf("Error: %s", err)
+	if _, err := os.Stat(baseDir); err == nil && len(recheck) > 0 {
+		fmt.Printf("Rechecking %d pieces already on disk\n", len(recheck))
+		verified := download.Verify(t, outDir, recheck, nil)
+		for i := range bitfield {
+			bitfield[i] |= verified[i]
 		}
 	}
 
-	return nil, err
+	downloaded.Load(bitfield)
+	var completed uint64
+	for i := range t.Info.PieceCount {
+		if bitfield[i/8]&byte(1<<(7-i%8)) != 0 {
+			completed += download.PieceLength(t, i)
+		}
+	}
+	st.SetCompleted(completed, downloaded.GetPieceCount())
+	fmt.Printf("Resuming with %d/%d pieces\n", downloaded.GetPieceCount(), t.Info.PieceCount)
+}
+
+// resumeLock keeps resume data from being saved while the storage moves
+var resumeLock sync.Mutex
+
+func saveResume(t *parser.Torrent, storage *download.Storage, downloaded *utils.Downloaded, st *stats.Torrent, known *utils.AvailablePeers) {
+	resumeLock.Lock()
+	defer resumeLock.Unlock()
+
+	outDir := storage.OutDir()
+	baseDir := storage.BaseDir()
+	rd := resume.New(t.InfoHash, downloaded.GetContent(), t.Info.PieceCount, baseDir, download.PieceFiles(t, baseDir), st.Uploaded(), st.Downloaded(), known.List())
+	if err := rd.Save(resume.FilePath(outDir, t.InfoHash)); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to save resume data:", err)
+	}
+}
+
+// moveStorage moves the torrent's data and its resume data to a new directory
+func moveStorage(t *parser.Torrent, storage *download.Storage, newOutDir string) error {
+	resumeLock.Lock()
+	defer resumeLock.Unlock()
+
+	oldOutDir := storage.OutDir()
+	if err := storage.Move(newOutDir); err != nil {
+		return err
+	}
+	if err := os.Rename(resume.FilePath(oldOutDir, t.InfoHash), resume.FilePath(newOutDir, t.InfoHash)); err != nil && !os.IsNotExist(err) {
+		// the next save writes fresh resume data at the new location anyway
+		os.Remove(resume.FilePath(oldOutDir, t.InfoHash))
+	}
+	return nil
 }
 
 func getNextPieceIndex(downloaded []byte, bitField []byte, downloading *utils.DownloadingSet) (int, int, uint32, error) {
@@ -88,15 +182,23 @@ peer: struct containing info about peer that the tracker sent (IP, Port)
 t: struct containing parsed torrent information
 downloaded: slice containing info about all the pieces that have been downloaded
 downloading: channel containing info about all the pieces that are currently downloading
-wg: waitgroup to create a joining point to the main function
+attempt: reports the handshake and the good data to the connection manager
 */
 
-func HandshakeNDownload(peer *parser.Peer, t *parser.Torrent, downloaded *utils.Downloaded, peerId []byte, downloading *utils.DownloadingSet, outDir string, peerList []parser.Peer) error {
+func HandshakeNDownload(peer *parser.Peer, t *parser.Torrent, downloaded *utils.Downloaded, peerId []byte, downloading *utils.DownloadingSet, disk *download.DiskIO, st *stats.Torrent, known *utils.AvailablePeers, limits *ratelimit.TorrentLimits, ban *download.SmartBan, attempt *peers.Attempt) error {
+	if ban.Banned(peer.Ip.String()) {
+		return fmt.Errorf("%s is banned", peer.Ip.String())
+	}
 	peer, err := peers.PerformHandshake(*peer, t.InfoHash, peerId, downloaded)
 	if err != nil || peer.Conn == nil {
 		return err
 	}
 	defer peer.Conn.Close()
+	attempt.Established(peer.Conn)
+	peerStats := st.AddPeer(net.JoinHostPort(peer.Ip.String(), strconv.Itoa(int(peer.Port))))
+	defer st.RemovePeer(peerStats)
+	peer.Conn = st.Wrap(limits.WrapPeer(peer.Conn), peerStats)
+	known.Add(parser.Peer{Ip: peer.Ip, Port: peer.Port})
 
 	intr := peers.SendInterested(peer.Conn)
 	if !intr {
@@ -105,8 +207,15 @@ func HandshakeNDownload(peer *parser.Peer, t *parser.Torrent, downloaded *utils.
 	}
 	fmt.Printf("%s has unchoked you. Now requesting a piece\n", peer.Ip.String())
 
+	// pieces handed to the hashing pool are checked and written while we go on requesting
+	var verifying sync.WaitGroup
+	defer verifying.Wait()
+
 	// download all the available pieces that peer offers
 	for {
+		if ban.Banned(peer.Ip.String()) {
+			return fmt.Errorf("%s is banned", peer.Ip.String())
+		}
 		tmp := append([]byte(nil), downloaded.GetContent()...)
 		dIndex, bIndex, pieceIndex, err := getNextPieceIndex(tmp, peer.Bitfield, downloading)
 		if err != nil {
@@ -118,57 +227,241 @@ func HandshakeNDownload(peer *parser.Peer, t *parser.Torrent, downloaded *utils.
 
 		downloading.Add(pieceIndex)
 
-		piece, err := download.DownloadPiece(peer.Conn, peer.Bitfield, t, pieceIndex)
+		piece, err := download.FetchPiece(peer.Conn, peer.Bitfield, t, pieceIndex)
 		if err != nil {
-			downloaded.Remove(dIndex, bIndex)
+			downloading.Remove(pieceIndex)
 			break
 		}
-		fmt.Printf("Downloaded piece index %d from peer %s\n", pieceIndex, peer.Ip.String())
-		downloaded.Add(dIndex, bIndex)
-		downloading.Remove(pieceIndex)
-		peers.SendHavePiece(peerList, pieceIndex)
+		st.PayloadDown(peerStats, uint64(len(piece)))
+
+		// Submit blocks while the pool is behind, so the peer waits instead of piling up pieces waiting for verification
+		hash := hashing.Default.Submit(piece)
+		verifying.Add(1)
+		go func() {
+			defer verifying.Done()
+			verifyPiece(t, pieceIndex, piece, <-hash, peer.Ip.String(), downloaded, downloading, disk, st, ban, attempt.Credit)
+		}()
+	}
+	return nil
+}
+
+// fullBitfield is the bitfield of a peer that has every piece
+func fullBitfield(pieceCount uint32) []byte {
+	bitfield := make([]byte, getDownloadedLen(pieceCount))
+	for i := uint32(0); i < pieceCount; i++ {
+		bitfield[i/8] |= 1 << (7 - i%8)
+	}
+	return bitfield
+}
+
+/*
+WebSeedDownload downloads pieces from a web seed (BEP 19 or BEP 17) the way
+HandshakeNDownload does from a peer, the web seed being a peer that has every
+piece. It keeps going until every piece is downloaded or stop is closed, and
+gives up on the web seed after WEBSEED_MAX_FAILURES failed requests in a row.
+A busy seed is waited for as long as it asks, which does not count as a
+failure.
+*/
+func WebSeedDownload(seedURL string, newSeed func(client *http.Client) download.Seed, t *parser.Torrent, downloaded *utils.Downloaded, downloading *utils.DownloadingSet, disk *download.DiskIO, st *stats.Torrent, limits *ratelimit.TorrentLimits, ban *download.SmartBan, stop <-chan struct{}) error {
+	peerStats := st.AddPeer(seedURL)
+	defer st.RemovePeer(peerStats)
+	client := download.NewWebSeedClient(func(conn net.Conn) net.Conn {
+		return st.Wrap(limits.WrapPeer(conn), peerStats)
+	})
+	seed := newSeed(client)
+
+	var verifying sync.WaitGroup
+	defer verifying.Wait()
+
+	// wait returns false when stop was closed in the meantime
+	wait := func(d time.Duration) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-stop:
+			return false
+		case <-timer.C:
+			return true
+		}
+	}
+
+	bitfield := fullBitfield(t.Info.PieceCount)
+	failures := 0
+	for downloaded.GetPieceCount() != t.Info.PieceCount {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		if ban.Banned(seedURL) {
+			return fmt.Errorf("%s is banned", seedURL)
+		}
+		tmp := append([]byte(nil), downloaded.GetContent()...)
+		dIndex, bIndex, pieceIndex, err := getNextPieceIndex(tmp, bitfield, downloading)
+		if err != nil || dIndex == -1 || bIndex == -1 {
+			// a piece being downloaded from a peer may still fail
+			if !wait(WEBSEED_IDLE) {
+				return nil
+			}
+			continue
+		}
 
-		err = download.WritePiece(pieceIndex, piece, filepath.Join(outDir, t.Info.Name))
+		downloading.Add(pieceIndex)
+		piece, err := seed.FetchPiece(t, pieceIndex)
+		var retry *download.RetryError
+		if errors.As(err, &retry) {
+			downloading.Remove(pieceIndex)
+			fmt.Printf("Web seed %s is busy, retrying in %s\n", seedURL, retry.After)
+			if !wait(retry.After) {
+				return nil
+			}
+			continue
+		}
 		if err != nil {
-			downloaded.Remove(dIndex, bIndex)
-			return err
+			downloading.Remove(pieceIndex)
+			if failures++; failures >= WEBSEED_MAX_FAILURES {
+				return fmt.Errorf("giving up on web seed %s: %w", seedURL, err)
+			}
+			fmt.Fprintf(os.Stderr, "Web seed %s failed: %v\n", seedURL, err)
+			if !wait(WEBSEED_RETRY * time.Duration(failures)) {
+				return nil
+			}
+			continue
 		}
+		failures = 0
+		st.PayloadDown(peerStats, uint64(len(piece)))
+
+		// same back-pressure as for peers, a fast web seed cannot pile up pieces either
+		hash := hashing.Default.Submit(piece)
+		verifying.Add(1)
+		go func() {
+			defer verifying.Done()
+			verifyPiece(t, pieceIndex, piece, <-hash, seedURL, downloaded, downloading, disk, st, ban, func(uint64) {})
+		}()
 	}
 	return nil
 }
 
+/*
+verifyPiece compares the hash of a fetched piece, computed by the hashing
+pool, with the torrent's and hands the piece to the disk when it is good. source is whoever sent it (peer IP or web seed url) for
+SmartBan, credit gets the size of a good piece.
+*/
+func verifyPiece(t *parser.Torrent, pieceIndex uint32, piece []byte, hash []byte, source string, downloaded *utils.Downloaded, downloading *utils.DownloadingSet, disk *download.DiskIO, st *stats.Torrent, ban *download.SmartBan, credit func(uint64)) {
+	if err := download.CheckPiece(t, pieceIndex, hash); err != nil {
+		fmt.Fprintf(os.Stderr, "Piece %d from %s failed verification: %v\n", pieceIndex, source, err)
+		st.PieceFailed(uint64(len(piece)))
+		for _, banned := range ban.PieceFailed(pieceIndex, piece, download.BlockSources(t, pieceIndex, source)) {
+			fmt.Fprintf(os.Stderr, "Banned %s for taking part in repeated hash failures\n", banned)
+		}
+		downloading.Remove(pieceIndex)
+		return
+	}
+	credit(uint64(len(piece)))
+	for _, banned := range ban.PieceVerified(pieceIndex, piece) {
+		fmt.Fprintf(os.Stderr, "Banned %s for sending corrupt data\n", banned)
+	}
+	disk.Write(pieceIndex, piece, func(err error) {
+		defer downloading.Remove(pieceIndex)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return
+		}
+		fmt.Printf("Downloaded piece index %d from %s\n", pieceIndex, source)
+		downloaded.Add(int(pieceIndex/8), int(pieceIndex%8))
+		st.PieceCompleted(uint64(len(piece)))
+	})
+}
+
 func main() {
 	/*
 		args => command line arguments
-		wg => wait group to synchronize main with handshakeNdownload
 		downloading => map to mark the pieces that are downloading
-		threadLimit => to limit maximum concurrent downloads to value of CONCURRENT_DOWNLOADS
 	*/
-	args := os.Args
-	var wg sync.WaitGroup
 	downloading := utils.NewDownloadingSet()
-	threadLimit := make(chan struct{}, CONCURRENT_DONWLOADS)
 
-	// Exit if no file path is passed
-	if len(args) < 3 {
-		fmt.Fprintln(os.Stderr, "Usage: ./torrent-client [file path] [out path]")
-		os.Exit(1)
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		verifyCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "scrape" {
+		scrapeCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "create" {
+		createCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "tracker" {
+		trackerCommand(os.Args[2:])
+		return
 	}
-	// check for file and path validity
-	check(args[1], args[2])
 
-	// Read file and Get decoded struct
-	data, err := os.ReadFile(args[1])
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Read: ", err)
-		os.Exit(1)
+	allocate := flag.String("allocate", "sparse", "how to create the files: sparse or full (preallocated)")
+	maxConns := flag.Int("max-conns", CONCURRENT_DONWLOADS, "maximum number of peer connections")
+	maxHalfOpen := flag.Int("max-half-open", MAX_HALF_OPEN, "maximum number of peer connections still connecting")
+	announceAll := flag.Bool("announce-all", false, "announce to every tracker at once instead of the first one that works")
+	blocklistPath := flag.String("blocklist", "", "file with IP ranges not to connect to (ipfilter.dat, P2P or CIDR), reloaded when it changes")
+	moveTo := flag.String("move-to", "", "directory to move the data to once the download completes")
+	downLimit := flag.Int64("down", 0, "global download limit in KiB/s, 0 for unlimited")
+	upLimit := flag.Int64("up", 0, "global upload limit in KiB/s, 0 for unlimited")
+	torrentDownLimit := flag.Int64("torrent-down", 0, "download limit of this torrent in KiB/s")
+	torrentUpLimit := flag.Int64("torrent-up", 0, "upload limit of this torrent in KiB/s")
+	peerDownLimit := flag.Int64("peer-down", 0, "download limit per peer in KiB/s")
+	peerUpLimit := flag.Int64("peer-up", 0, "upload limit per peer in KiB/s")
+	altSchedule := flag.String("alt-schedule", "", "when the alternate limits apply, e.g. \"mon-fri 09:00-17:00\"")
+	altDownLimit := flag.Int64("alt-down", 0, "global download limit in KiB/s during the alternate schedule")
+	altUpLimit := flag.Int64("alt-up", 0, "global upload limit in KiB/s during the alternate schedule")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ./torrent-client [options] [file path] [out path]")
+		fmt.Fprintln(os.Stderr, "       ./torrent-client verify [file path] [out path]")
+		fmt.Fprintln(os.Stderr, "       ./torrent-client scrape [file path]...")
+		fmt.Fprintln(os.Stderr, "       ./torrent-client create [options] [path]")
+		fmt.Fprintln(os.Stderr, "       ./torrent-client tracker [-listen :6969] [-udp :6969] [-allow file] [-interval 30m]")
+		flag.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "While downloading, type \"move [dir]\" to move the data to another directory")
+		fmt.Fprintln(os.Stderr, "or \"limit [global|torrent|peer] [down|up] [KiB/s]\" to change a rate limit.")
 	}
+	flag.Parse()
+	args := flag.Args()
 
-	t, err := parser.DecodeTorrent(data)
+	// Exit if no file path is passed
+	if len(args) < 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	allocation, err := download.ParseAllocationMode(*allocate)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error while reading torrent file: ", err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	var schedule *ratelimit.Schedule
+	if *altSchedule != "" {
+		schedule, err = ratelimit.ParseSchedule(*altSchedule, *altDownLimit*1024, *altUpLimit*1024)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	scheduler := ratelimit.NewScheduler(ratelimit.Global, *downLimit*1024, *upLimit*1024, schedule)
+	go scheduler.Run(nil)
+	limits := ratelimit.NewTorrentLimits(*torrentDownLimit*1024, *torrentUpLimit*1024, *peerDownLimit*1024, *peerUpLimit*1024)
+	var blocked *blocklist.List
+	if *blocklistPath != "" {
+		blocked, err = blocklist.Load(*blocklistPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to load blocklist:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Loaded blocklist %s: %d ranges\n", *blocklistPath, blocked.Len())
+	}
+
+	// check for file and path validity
+	check(args[0], args[1])
+	outDir := args[1]
+
+	// Read file and Get decoded struct
+	t := readTorrent(args[0])
 
 	// [DEBUG]
 	// fmt.Println("Files: ")
@@ -179,67 +472,176 @@ func main() {
 	downloaded := utils.NewDownloaded(getDownloadedLen(t.Info.PieceCount))
 	// test last piece
 	// downloaded.SetAll(t.Info.PieceCount - 1)
+	st := stats.NewTorrent(t.TotalLength, t.Info.PieceCount)
+	ban := download.NewSmartBan()
+	known := utils.NewPeerList(nil)
+	storage := download.NewStorage(t, outDir)
+	if err := storage.CheckFreeSpace(); err != nil {
+		fmt.Fprintln(os.Stderr, "Refusing to start:", err)
+		os.Exit(1)
+	}
+	if err := download.ImportPieceFiles(t, storage); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to import piece files:", err)
+		os.Exit(1)
+	}
+	loadResume(t, outDir, downloaded, st, known)
+	// only allocate after the recheck so it does not hash freshly created empty files
+	if err := storage.Allocate(allocation); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to create files:", err)
+		os.Exit(1)
+	}
+
+	go st.Run(nil)
+
+	// persist resume data periodically and when the process is interrupted
+	go func() {
+		ticker := time.NewTicker(resume.SAVE_INTERVAL)
+		defer ticker.Stop()
+		for range ticker.C {
+			saveResume(t, storage, downloaded, st, known)
+		}
+	}()
+	disk := download.NewDiskIO(t, storage, download.DISK_CACHE_SIZE)
 
 	peerId := peers.GetPeerId()
 	fmt.Printf("Total Length: %d, Piece Length: %d, block size: %d, Piece Count: %d\n", t.TotalLength, t.Info.PieceLength, download.BLOCK_SIZE, t.Info.PieceCount)
-	res, err := GetPeers(t)
-	for {
-		// 1. get peers from traker
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to get peers from any tracker: %v\nRetrying in 30 seconds...\n", err)
-			time.Sleep(30 * time.Second)
-			continue
-		}
 
-		// 2. send interested to all the peers and wait for unchoke
-		// 3. when unchoked get the bitfield and get next downloadable piece
-		if len(res.Peers) == 0 {
-			fmt.Printf("No peers found, retrying in %d seconds...\n", res.Interval)
-			// fmt.Println("Requesting a fresh list of peers from the tracker")
-			time.Sleep(time.Duration(res.Interval))
-			res, err = GetPeers(t)
-			continue
+	// 1. the trackers keep feeding peers to the connection manager
+	// 2. the manager connects to the best of them, replacing connections as they end
+	// 3. each connection sends interested, waits for unchoke and downloads what the peer offers
+	mgr := peers.NewManager(*maxConns, *maxHalfOpen, func(p parser.Peer, a *peers.Attempt) error {
+		return HandshakeNDownload(&p, t, downloaded, []byte(peerId), downloading, disk, st, known, limits, ban, a)
+	})
+	mgr.AddFilter(func(p parser.Peer) bool {
+		return !ban.Banned(p.Ip.String())
+	})
+	if blocked != nil {
+		notBlocked := func(p parser.Peer) bool {
+			return !blocked.Blocked(p.Ip)
 		}
-
-		for _, peer := range res.Peers {
-			if peer.Ip.String() == "0.0.0.0" {
-				continue
+		mgr.AddFilter(notBlocked)
+		// the filter keeps new connections out, peers already connected are dropped here
+		go blocked.Watch(nil, func() {
+			if n := mgr.Disconnect(notBlocked); n > 0 {
+				fmt.Printf("Disconnected %d peers blocked by the reloaded blocklist\n", n)
 			}
-
-			wg.Add(1)
-			threadLimit <- struct{}{}
-			go func(p parser.Peer) {
-				defer func() {
-					<-threadLimit
-					wg.Done()
-				}()
-
-				// 4. download the piece
-				err := HandshakeNDownload(&p, t, downloaded, []byte(peerId), downloading, args[2], res.Peers)
-				if err != nil {
-					// 	if err == io.EOF {
-					// 		fmt.Fprintln(os.Stderr, "Error:", peer.Ip.String(), "dropped connection")
-					// 		return
-					// 	}
-					// 	fmt.Printf("Error: %s\n", err)
-					// 	return
+		})
+	}
+	if t.Info.Private {
+		// BEP 27: no peers but the ones of the torrent's trackers, not even those remembered in the resume data
+		fmt.Println("Private torrent, only using peers from its trackers")
+		mgr.RestrictSources(peers.Trackers(t))
+	}
+	mgr.Add(known.List(), "resume")
+
+	announcer := peers.NewAnnouncer(t, func() (uint64, uint64, uint64) {
+		down, up := st.Session()
+		return up, down, st.Left()
+	}, func(found []parser.Peer, source string) {
+		mgr.Add(found, source)
+	})
+	announcer.SetAnnounceAll(*announceAll)
+
+	// poll the statistics for a status line, and the trackers every minute
+	go func() {
+		ticks := 0
+		for range time.Tick(5 * time.Second) {
+			fmt.Println(st.Snapshot())
+			if ticks++; ticks%12 == 0 {
+				for _, status := range announcer.Status() {
+					fmt.Println(" ", status)
+				}
+			}
+		}
+	}()
+
+	stop := make(chan struct{})
+	announceStop := make(chan struct{})
+	announcing := downloaded.GetPieceCount() != t.Info.PieceCount
+	stopAnnouncer := sync.OnceFunc(func() {
+		close(announceStop)
+		if announcing {
+			select {
+			case <-announcer.Done():
+			case <-time.After(peers.STOPPED_TIMEOUT):
+			}
+		}
+	})
+	managerDone := make(chan struct{})
+	var webSeeds sync.WaitGroup
+	if announcing {
+		go func() {
+			mgr.Run(stop)
+			close(managerDone)
+		}()
+		go announcer.Run(announceStop)
+		seeds := make(map[string]func(*http.Client) download.Seed)
+		for _, seedURL := range t.UrlList {
+			seeds[seedURL] = func(client *http.Client) download.Seed { return download.NewWebSeed(t, seedURL, client) }
+		}
+		for _, seedURL := range t.HttpSeeds {
+			seeds[seedURL] = func(client *http.Client) download.Seed { return download.NewHTTPSeed(t, seedURL, client) }
+		}
+		for seedURL, newSeed := range seeds {
+			webSeeds.Add(1)
+			go func() {
+				defer webSeeds.Done()
+				if err := WebSeedDownload(seedURL, newSeed, t, downloaded, downloading, disk, st, limits, ban, stop); err != nil {
+					fmt.Fprintln(os.Stderr, err)
 				}
-			}(peer)
+			}()
 		}
-		// wait for all downloads in this batch to complete
-		wg.Wait()
+	} else {
+		close(managerDone)
+	}
 
-		// 5. after you've gone through all the peers but you still dont have all the pieces repeat all the steps again
-		if downloaded.GetPieceCount() == t.Info.PieceCount {
-			break
-		} else {
-			time.Sleep(15 * time.Second)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		stopAnnouncer()
+		disk.Close()
+		saveResume(t, storage, downloaded, st, known)
+		os.Exit(1)
+	}()
+
+	// "move [dir]" on stdin relocates the data while the torrent keeps running, "limit ..." changes a rate limit
+	go readControl(os.Stdin, func(dir string) error {
+		if err := moveStorage(t, storage, dir); err != nil {
+			return err
+		}
+		fmt.Println("Moved download to", storage.BaseDir())
+		saveResume(t, storage, downloaded, st, known)
+		return nil
+	}, func(level string, dir string, rate int64) error {
+		if err := setLimit(scheduler, limits, level, dir, rate); err != nil {
+			return err
 		}
+		fmt.Printf("Set the %s %s limit to %d KiB/s\n", level, dir, rate/1024)
+		return nil
+	})
 
-		// wg.Wait()
+	// 4. wait until every piece is verified and on disk
+	for downloaded.GetPieceCount() != t.Info.PieceCount {
+		time.Sleep(time.Second)
 	}
-
-	download.AssembleFiles(t, args[2], true)
+	announcer.Completed()
+	close(stop)
+	<-managerDone
+	webSeeds.Wait()
+	stopAnnouncer()
+
+	disk.Close()
+	fmt.Printf("Disk: %+v\n", disk.Stats())
+	if *moveTo != "" {
+		if err := moveStorage(t, storage, *moveTo); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to move the download:", err)
+		} else {
+			fmt.Println("Moved download to", storage.BaseDir())
+		}
+	}
+	// record the finished files so a rerun does not download them again
+	saveResume(t, storage, downloaded, st, known)
 }
 
 // [DEBUG] -> ASSEMBLE TESTING
@@ -20,6 +20,8 @@ type Torrent struct {
 	HasMultipleFiles bool
 	TotalLength      uint64
 	Magnet           string
+	UrlList          []string // web seeds (BEP 19)
+	HttpSeeds        []string // BEP 17 seeds
 }
 
 type InfoDict struct {
@@ -74,14 +76,14 @@ func (r *Reader) expectByte(b byte) error {
 }
 
 func (r *Reader) peek() (byte, error) {
-	if r.pos > len(r.b) {
+	if r.pos >= len(r.b) {
 		return 0, io.ErrUnexpectedEOF
 	}
 	return r.b[r.pos], nil
 }
 
 func (r *Reader) readString() (string, error) {
-	len := 0
+	size := 0
 
 	for {
 		ch, err := r.readByte()
@@ -91,7 +93,7 @@ func (r *Reader) readString() (string, error) {
 
 		n := ch - '0'
 		if n <= 9 {
-			len = len*10 + int(n)
+			size = size*10 + int(n)
 		} else if ch == ':' {
 			break
 		} else {
@@ -99,8 +101,11 @@ func (r *Reader) readString() (string, error) {
 		}
 	}
 
-	r.pos += len
-	return string(r.b[r.pos-len : r.pos]), nil
+	if size > len(r.b)-r.pos {
+		return "", io.ErrUnexpectedEOF
+	}
+	r.pos += size
+	return string(r.b[r.pos-size : r.pos]), nil
 }
 
 func (r *Reader) readStringList() ([]string, error) {
@@ -395,6 +400,33 @@ func (r *Reader) readInfo() (*InfoDict, error) {
 	return &info, nil
 }
 
+// readUrls reads a single url or a list of them, leaving out empty ones
+func (r *Reader) readUrls() ([]string, error) {
+	ch, err := r.peek()
+	if err != nil {
+		return nil, err
+	}
+	var elems []string
+	if ch == 'l' {
+		elems, err = r.readStringList()
+	} else {
+		var s string
+		s, err = r.readString()
+		elems = []string{s}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var urls []string
+	for _, u := range elems {
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls, nil
+}
+
 // TORRENT FUNCTIONS
 func DecodeTorrent(data []byte) (*Torrent, error) {
 	r := NewReader(data)
@@ -436,6 +468,20 @@ func DecodeTorrent(data []byte) (*Torrent, error) {
 			}
 			meta.AnnounceList = elems
 
+		case "url-list":
+			urls, err := r.readUrls()
+			if err != nil {
+				return nil, err
+			}
+			meta.UrlList = urls
+
+		case "httpseeds":
+			urls, err := r.readUrls()
+			if err != nil {
+				return nil, err
+			}
+			meta.HttpSeeds = urls
+
 		case "comment":
 			s, err := r.readString()
 			if err != nil {
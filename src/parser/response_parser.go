@@ -1,10 +1,8 @@
 package parser
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
-	"io"
 	"net"
 )
 
@@ -18,10 +16,21 @@ import (
 // e
 // e
 // e
+//
+// or with compact=1 (BEP 23) the peers as a string of 6 bytes per peer:
+// d 8:intervali900e 5:peers12:<ip><port><ip><port> 6:peers618:<ip6><port> e
+//
+// A tracker that refuses the announce only sends d 14:failure reason ... e
 
 type Response struct {
-	Interval uint32
-	Peers    []Peer
+	Interval       uint32
+	MinInterval    uint32 // 0 when the tracker sent none
+	TrackerId      string // to send back on later announces
+	Complete       uint32 // seeders
+	Incomplete     uint32 // leechers
+	FailureReason  string // the announce failed, nothing else is meaningful
+	WarningMessage string
+	Peers          []Peer
 }
 
 type Peer struct {
@@ -68,6 +77,10 @@ func (r *Reader) decodePeer() (*Peer, error) {
 				return nil, err
 			}
 			peer.Port = uint16(port)
+		default:
+			if err := r.skipAny(); err != nil {
+				return nil, err
+			}
 		}
 	}
 	return &peer, nil
@@ -135,42 +148,248 @@ func (r *Reader) DecodeHttpResponse() (*Response, error) {
 			}
 			res.Interval = uint32(i)
 
+		case "min interval":
+			i, err := r.readInt()
+			if err != nil {
+				return nil, err
+			}
+			res.MinInterval = uint32(i)
+
+		case "complete":
+			i, err := r.readInt()
+			if err != nil {
+				return nil, err
+			}
+			res.Complete = uint32(i)
+
+		case "incomplete":
+			i, err := r.readInt()
+			if err != nil {
+				return nil, err
+			}
+			res.Incomplete = uint32(i)
+
+		case "failure reason":
+			str, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			res.FailureReason = str
+
+		case "warning message":
+			str, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			res.WarningMessage = str
+
+		case "tracker id":
+			str, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			res.TrackerId = str
+
 		case "peers":
-			p, err := r.decodePeers()
+			ch, err := r.peek()
+			if err != nil {
+				return nil, err
+			}
+			var p []Peer
+			if ch == 'l' {
+				p, err = r.decodePeers()
+			} else {
+				var compact string
+				compact, err = r.readString()
+				if err == nil {
+					p, err = DecodeUDPResponse([]byte(compact))
+				}
+			}
 			if err != nil {
 				return nil, err
 			}
-			res.Peers = p
+			res.Peers = append(res.Peers, p...)
+
+		case "peers6":
+			compact, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			p, err := DecodeUDP6Response([]byte(compact))
+			if err != nil {
+				return nil, err
+			}
+			res.Peers = append(res.Peers, p...)
+
+		default:
+			if err := r.skipAny(); err != nil {
+				return nil, err
+			}
 		}
 	}
 
 	return &res, nil
 }
 
-func DecodeUDPResponse(peersBin []byte) ([]Peer, error) {
-	if len(peersBin)%6 != 0 {
+// DecodeCompactPeers decodes the compact peer format: ipLen bytes of address followed by a 2 byte port per peer
+func DecodeCompactPeers(peersBin []byte, ipLen int) ([]Peer, error) {
+	entryLen := ipLen + 2
+	if len(peersBin)%entryLen != 0 {
 		return nil, fmt.Errorf("invalid peers list length: %d", len(peersBin))
 	}
-	numPeers := len(peersBin) / 6
-	peers := make([]Peer, 0, numPeers)
-	reader := bytes.NewReader(peersBin)
+	peers := make([]Peer, 0, len(peersBin)/entryLen)
+	for i := 0; i < len(peersBin); i += entryLen {
+		ip := make(net.IP, ipLen)
+		copy(ip, peersBin[i:i+ipLen])
+		peers = append(peers, Peer{
+			Ip:   ip,
+			Port: binary.BigEndian.Uint16(peersBin[i+ipLen : i+entryLen]),
+		})
+	}
+	return peers, nil
+}
+
+// DecodeUDPResponse decodes the 6 byte IPv4 peer entries of a tracker response
+func DecodeUDPResponse(peersBin []byte) ([]Peer, error) {
+	return DecodeCompactPeers(peersBin, net.IPv4len)
+}
+
+// DecodeUDP6Response decodes the 18 byte IPv6 peer entries of a tracker response (BEP 7)
+func DecodeUDP6Response(peersBin []byte) ([]Peer, error) {
+	return DecodeCompactPeers(peersBin, net.IPv6len)
+}
+
+// d 5:files d 20:<info hash> d 8:completei5e 10:downloadedi50e 10:incompletei10e e e e
+
+type ScrapeFile struct {
+	Complete   uint32 // seeders
+	Downloaded uint32 // snatches, completed downloads ever reported
+	Incomplete uint32 // leechers
+}
+
+type ScrapeResponse struct {
+	Files         map[[20]byte]ScrapeFile
+	FailureReason string
+}
+
+func (r *Reader) decodeScrapeFile() (*ScrapeFile, error) {
+	var file ScrapeFile
+	err := r.expectByte('d')
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		ch, err := r.peek()
+		if err != nil {
+			return nil, err
+		}
 
-	for range numPeers {
-		var peer struct {
-			IP   [4]byte
-			Port uint16
+		if ch == 'e' {
+			r.readByte()
+			break
 		}
-		if err := binary.Read(reader, binary.BigEndian, &peer); err != nil {
-			if err == io.EOF {
-				break
+
+		key, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		switch key {
+		case "complete":
+			i, err := r.readInt()
+			if err != nil {
+				return nil, err
 			}
+			file.Complete = uint32(i)
+
+		case "downloaded":
+			i, err := r.readInt()
+			if err != nil {
+				return nil, err
+			}
+			file.Downloaded = uint32(i)
+
+		case "incomplete":
+			i, err := r.readInt()
+			if err != nil {
+				return nil, err
+			}
+			file.Incomplete = uint32(i)
+
+		default:
+			if err := r.skipAny(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &file, nil
+}
+
+func (r *Reader) DecodeScrapeResponse() (*ScrapeResponse, error) {
+	res := ScrapeResponse{Files: make(map[[20]byte]ScrapeFile)}
+
+	err := r.expectByte('d')
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		ch, err := r.peek()
+		if err != nil {
 			return nil, err
 		}
-		p := Peer{
-			Ip:   net.IP(peer.IP[:]),
-			Port: peer.Port,
+
+		if ch == 'e' {
+			r.readByte()
+			break
+		}
+
+		key, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		switch key {
+		case "files":
+			if err := r.expectByte('d'); err != nil {
+				return nil, err
+			}
+			for {
+				ch, err := r.peek()
+				if err != nil {
+					return nil, err
+				}
+				if ch == 'e' {
+					r.readByte()
+					break
+				}
+				hash, err := r.readString()
+				if err != nil {
+					return nil, err
+				}
+				file, err := r.decodeScrapeFile()
+				if err != nil {
+					return nil, err
+				}
+				if len(hash) == 20 {
+					res.Files[[20]byte([]byte(hash))] = *file
+				}
+			}
+
+		case "failure reason":
+			str, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			res.FailureReason = str
+
+		default:
+			if err := r.skipAny(); err != nil {
+				return nil, err
+			}
 		}
-		peers = append(peers, p)
 	}
-	return peers, nil
+
+	return &res, nil
 }
@@ -0,0 +1,163 @@
+package parser
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// the compact forms of 1.2.3.4:6881 and [2001:db8::1]:51413
+const (
+	compactPeer  = "\x01\x02\x03\x04\x1a\xe1"
+	compactPeer6 = "\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\xc8\xd5"
+)
+
+var (
+	hashA = strings.Repeat("a", 20)
+	hashB = strings.Repeat("b", 20)
+)
+
+func TestDecodeHttpResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Response
+		peers   []string // ip:port of the peers, in order
+		invalid bool
+	}{
+		{
+			name:  "failure reason",
+			input: "d14:failure reason11:not allowede",
+			want:  Response{FailureReason: "not allowed"},
+		},
+		{
+			name:  "warning message",
+			input: "d8:intervali900e15:warning message8:too fast5:peers0:e",
+			want:  Response{Interval: 900, WarningMessage: "too fast"},
+		},
+		{
+			name:  "interval, min interval, counts and tracker id",
+			input: "d8:completei5e10:incompletei10e8:intervali1800e12:min intervali900e10:tracker id3:abc5:peers0:e",
+			want:  Response{Interval: 1800, MinInterval: 900, TrackerId: "abc", Complete: 5, Incomplete: 10},
+		},
+		{
+			name:  "compact peers",
+			input: "d8:intervali900e5:peers12:" + compactPeer + "\x05\x06\x07\x08\x00\x50e",
+			want:  Response{Interval: 900},
+			peers: []string{"1.2.3.4:6881", "5.6.7.8:80"},
+		},
+		{
+			name:  "dictionary peers",
+			input: "d8:intervali900e5:peersld2:ip7:1.2.3.47:peer id20:" + hashA + "4:porti6881eed2:ip11:2001:db8::14:porti51413eeee",
+			want:  Response{Interval: 900},
+			peers: []string{"1.2.3.4:6881", "[2001:db8::1]:51413"},
+		},
+		{
+			name:  "peers and peers6",
+			input: "d8:intervali900e5:peers6:" + compactPeer + "6:peers618:" + compactPeer6 + "e",
+			want:  Response{Interval: 900},
+			peers: []string{"1.2.3.4:6881", "[2001:db8::1]:51413"},
+		},
+		{
+			name:    "peers6 length not a multiple of 18",
+			input:   "d8:intervali900e6:peers617:" + compactPeer6[:17] + "e",
+			invalid: true,
+		},
+		{
+			name:    "peers length not a multiple of 6",
+			input:   "d8:intervali900e5:peers5:" + compactPeer[:5] + "e",
+			invalid: true,
+		},
+		{
+			name:  "unknown keys are skipped",
+			input: "d8:intervali900e7:privatei1e5:extrald1:xi1eel1:yee5:peers6:" + compactPeer + "4:zzzz0:e",
+			want:  Response{Interval: 900},
+			peers: []string{"1.2.3.4:6881"},
+		},
+		{name: "not a dictionary", input: "l8:intervali900ee", invalid: true},
+		{name: "truncated", input: "d8:intervali900e5:peers12:" + compactPeer, invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := NewReader([]byte(tt.input)).DecodeHttpResponse()
+			if (err != nil) != tt.invalid {
+				t.Fatalf("error %v, want invalid %v", err, tt.invalid)
+			}
+			if tt.invalid {
+				return
+			}
+			peers := res.Peers
+			res.Peers = nil
+			if res.Interval != tt.want.Interval || res.MinInterval != tt.want.MinInterval || res.TrackerId != tt.want.TrackerId ||
+				res.Complete != tt.want.Complete || res.Incomplete != tt.want.Incomplete ||
+				res.FailureReason != tt.want.FailureReason || res.WarningMessage != tt.want.WarningMessage {
+				t.Errorf("got %+v, want %+v", *res, tt.want)
+			}
+			if len(peers) != len(tt.peers) {
+				t.Fatalf("got %d peers, want %d", len(peers), len(tt.peers))
+			}
+			for i, want := range tt.peers {
+				if got := net.JoinHostPort(peers[i].Ip.String(), strconv.Itoa(int(peers[i].Port))); got != want {
+					t.Errorf("peer %d: got %s, want %s", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeScrapeResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		files   map[string]ScrapeFile
+		failure string
+		invalid bool
+	}{
+		{
+			name:  "two torrents",
+			input: "d5:filesd20:" + hashA + "d8:completei5e10:downloadedi50e10:incompletei10ee20:" + hashB + "d8:completei0e10:downloadedi0e10:incompletei1eeee",
+			files: map[string]ScrapeFile{hashA: {Complete: 5, Downloaded: 50, Incomplete: 10}, hashB: {Incomplete: 1}},
+		},
+		{
+			name:  "unknown keys are skipped",
+			input: "d5:filesd20:" + hashA + "d8:completei1e4:namel1:xee" + "e5:flagsd20:min_request_intervali900eee",
+			files: map[string]ScrapeFile{hashA: {Complete: 1}},
+		},
+		{
+			name:  "a key that is not an info hash is left out",
+			input: "d5:filesd5:shortd8:completei1eeee",
+			files: map[string]ScrapeFile{},
+		},
+		{
+			name:    "failure",
+			input:   "d14:failure reason11:not allowede",
+			failure: "not allowed",
+			files:   map[string]ScrapeFile{},
+		},
+		{name: "not a dictionary", input: "l5:filese", invalid: true},
+		{name: "truncated", input: "d5:filesd20:" + hashA + "d8:complete", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := NewReader([]byte(tt.input)).DecodeScrapeResponse()
+			if (err != nil) != tt.invalid {
+				t.Fatalf("error %v, want invalid %v", err, tt.invalid)
+			}
+			if tt.invalid {
+				return
+			}
+			if res.FailureReason != tt.failure {
+				t.Errorf("failure reason %q, want %q", res.FailureReason, tt.failure)
+			}
+			if len(res.Files) != len(tt.files) {
+				t.Errorf("got %d torrents, want %d", len(res.Files), len(tt.files))
+			}
+			for hash, want := range tt.files {
+				if got := res.Files[[20]byte([]byte(hash))]; got != want {
+					t.Errorf("%s: got %+v, want %+v", hash[:1], got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package peers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"torrent-client/src/parser"
+)
+
+/*
+Announcer keeps a torrent announced to its trackers:
+
+- started on the first announce to a tracker
+- regular announces every interval the tracker asks for (sooner while it
+  has no peers for us, but never before its min interval)
+- completed once the download finishes
+- stopped to every tracker that got started when Run is stopped
+
+By default the trackers are tried in the order of the torrent file and the
+first one that answers is used until it fails. With SetAnnounceAll every
+tracker is announced to at the same time, each on its own interval, and all
+of their peers are handed on (the connection manager merges them by ip:port).
+Every announce carries the transfer counters from Progress at that moment.
+*/
+
+const ANNOUNCE_RETRY = 30 * time.Second   // after a failed announce
+const NO_PEERS_RETRY = 2 * time.Minute    // when the tracker knew no peers
+const STOPPED_TIMEOUT = 5 * time.Second   // how long shutdown waits for the stopped announces
+const DEFAULT_INTERVAL = 30 * time.Minute // for trackers that send no interval
+
+// Progress returns the transfer counters to announce
+type Progress func() (uploaded uint64, downloaded uint64, left uint64)
+
+// Found receives the peers of an announce, source is the url of the tracker they came from
+type Found func(peers []parser.Peer, source string)
+
+type Announcer struct {
+	t            *parser.Torrent
+	progress     Progress
+	found        Found
+	trackers     []Tracker
+	all          bool
+	completed    chan struct{} // closed once the download finished
+	completeOnce sync.Once
+	done         chan struct{}
+}
+
+// Trackers returns the announce urls of a torrent, main announce first and without duplicates
+func Trackers(t *parser.Torrent) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, url := range append([]string{t.Announce}, t.AnnounceList...) {
+		if url != "" && !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// newTrackers creates the trackers of the urls, skipping the ones no implementation exists for
+func newTrackers(urls []string) []Tracker {
+	var trackers []Tracker
+	for _, url := range urls {
+		tracker, err := NewTracker(url)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Skipping tracker:", err)
+			continue
+		}
+		trackers = append(trackers, tracker)
+	}
+	return trackers
+}
+
+// NewAnnouncer creates an announcer that hands the peers it gets to found
+func NewAnnouncer(t *parser.Torrent, progress Progress, found Found) *Announcer {
+	return &Announcer{
+		t:         t,
+		progress:  progress,
+		found:     found,
+		trackers:  newTrackers(Trackers(t)),
+		completed: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// SetAnnounceAll switches between announcing to every tracker and to the first working one, call it before Run
+func (a *Announcer) SetAnnounceAll(all bool) {
+	a.all = all
+}
+
+// params are the parameters of an announce that is abandoned when cancel is closed
+func (a *Announcer) params(event Event, cancel <-chan struct{}) AnnounceParams {
+	uploaded, downloaded, left := a.progress()
+	return AnnounceParams{Event: event, Uploaded: uploaded, Downloaded: downloaded, Left: left, Cancel: cancel}
+}
+
+// Completed announces that the download finished, Run sends it before stopping if it is stopped right away
+func (a *Announcer) Completed() {
+	a.completeOnce.Do(func() { close(a.completed) })
+}
+
+func (a *Announcer) isCompleted() bool {
+	select {
+	case <-a.completed:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+cancelFor is the cancel channel of an announce. A completed is never
+abandoned: the tracker may have counted it already, and sending it again on
+the way out would count the download twice. Like the stopped ones it is
+bounded by the tracker's own timeouts.
+*/
+func cancelFor(event Event, stop <-chan struct{}) <-chan struct{} {
+	if event == EVENT_COMPLETED {
+		return nil
+	}
+	return stop
+}
+
+// nextWait is how long to wait after an announce before the next regular one
+func nextWait(res *parser.Response, err error) time.Duration {
+	if err != nil {
+		return ANNOUNCE_RETRY
+	}
+	wait := DEFAULT_INTERVAL
+	if res.Interval > 0 {
+		wait = time.Duration(res.Interval) * time.Second
+	}
+	if len(res.Peers) == 0 {
+		fmt.Printf("No peers found, retrying in %s...\n", NO_PEERS_RETRY)
+		wait = max(min(wait, NO_PEERS_RETRY), time.Duration(res.MinInterval)*time.Second)
+	}
+	return wait
+}
+
+// Run announces until stop is closed, then announces stopped and returns
+func (a *Announcer) Run(stop <-chan struct{}) {
+	defer close(a.done)
+	if !a.all {
+		a.runFirst(stop)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, tracker := range a.trackers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.runTracker(tracker, stop)
+		}()
+	}
+	wg.Wait()
+}
+
+// runTracker keeps announcing to a single tracker on its own interval
+func (a *Announcer) runTracker(tracker Tracker, stop <-chan struct{}) {
+	started := false
+	completedSent := false
+	for {
+		event := EVENT_NONE
+		if !started {
+			event = EVENT_STARTED
+		} else if a.isCompleted() && !completedSent {
+			event = EVENT_COMPLETED
+		}
+		res, err := tracker.Announce(a.t, a.params(event, cancelFor(event, stop)))
+		if errors.Is(err, ErrCanceled) {
+			// stop was closed, handled below
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Announce to %s failed: %v\n", tracker.URL(), err)
+		} else {
+			started = true
+			completedSent = completedSent || event == EVENT_COMPLETED
+			a.found(res.Peers, tracker.URL())
+		}
+		completed := a.completed
+		if completedSent || !started {
+			// a tracker that never started is not told about the completion, it gets started first
+			completed = nil
+		}
+
+		wait := nextWait(res, err)
+		tracker.SetNextAnnounce(time.Now().Add(wait))
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			tracker.SetNextAnnounce(time.Time{})
+			if !started {
+				return
+			}
+			if a.isCompleted() && !completedSent {
+				tracker.Announce(a.t, a.params(EVENT_COMPLETED, nil))
+			}
+			if _, err := tracker.Announce(a.t, a.params(EVENT_STOPPED, nil)); err != nil {
+				fmt.Fprintf(os.Stderr, "Announcing stop to %s failed: %v\n", tracker.URL(), err)
+			}
+			return
+		case <-completed:
+			// completed goes out right away, the tracker's min interval only limits regular announces
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+/*
+runFirst announces to the current tracker, falling back to the next ones
+when it fails. A tracker that was not started yet gets started instead of a
+regular announce.
+*/
+func (a *Announcer) runFirst(stop <-chan struct{}) {
+	current := 0
+	started := make(map[string]bool)
+	announce := func(event Event, cancel <-chan struct{}) (*parser.Response, error) {
+		var err error
+		for i := range a.trackers {
+			idx := (current + i) % len(a.trackers)
+			tracker := a.trackers[idx]
+			url := tracker.URL()
+			ev := event
+			if ev == EVENT_NONE && !started[url] {
+				ev = EVENT_STARTED
+			}
+
+			var res *parser.Response
+			res, err = tracker.Announce(a.t, a.params(ev, cancel))
+			if errors.Is(err, ErrCanceled) {
+				return nil, err
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Announce to %s failed: %v\n", url, err)
+				continue
+			}
+			current = idx
+			started[url] = true
+			return res, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("the torrent has no trackers")
+		}
+		return nil, err
+	}
+
+	event := EVENT_NONE
+	completed := a.completed
+	for {
+		res, err := announce(event, cancelFor(event, stop))
+		if errors.Is(err, ErrCanceled) {
+			// stop was closed, handled below
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get peers from any tracker: %v\nRetrying in %s...\n", err, ANNOUNCE_RETRY)
+		} else {
+			event = EVENT_NONE
+			a.found(res.Peers, a.trackers[current].URL())
+		}
+
+		wait := nextWait(res, err)
+		if len(a.trackers) > 0 {
+			a.trackers[current].SetNextAnnounce(time.Now().Add(wait))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			// a completed that is still to be sent goes out first
+			if event == EVENT_COMPLETED || (completed != nil && a.isCompleted()) {
+				announce(EVENT_COMPLETED, nil)
+			}
+			for _, tracker := range a.trackers {
+				tracker.SetNextAnnounce(time.Time{})
+				if !started[tracker.URL()] {
+					continue
+				}
+				if _, err := tracker.Announce(a.t, a.params(EVENT_STOPPED, nil)); err != nil {
+					fmt.Fprintf(os.Stderr, "Announcing stop to %s failed: %v\n", tracker.URL(), err)
+				}
+			}
+			return
+		case <-completed:
+			// completed goes out right away, the tracker's min interval only limits regular announces
+			timer.Stop()
+			event = EVENT_COMPLETED
+			completed = nil
+		case <-timer.C:
+		}
+	}
+}
+
+// Status returns the status of every tracker of the torrent
+func (a *Announcer) Status() []TrackerStatus {
+	status := make([]TrackerStatus, len(a.trackers))
+	for i, tracker := range a.trackers {
+		status[i] = tracker.Status()
+	}
+	return status
+}
+
+// Done is closed once Run has returned
+func (a *Announcer) Done() <-chan struct{} {
+	return a.done
+}
@@ -0,0 +1,25 @@
+package peers
+
+import (
+	"net"
+	"time"
+)
+
+// IDLE_TIMEOUT is how long a peer may send nothing, twice the keep-alive interval of BEP 3
+const IDLE_TIMEOUT = 2 * time.Minute
+
+// IdleConn fails any read or write that makes no progress within Timeout, so a silent peer cannot hold a connection forever
+type IdleConn struct {
+	net.Conn
+	Timeout time.Duration
+}
+
+func (c *IdleConn) Read(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.Timeout))
+	return c.Conn.Read(b)
+}
+
+func (c *IdleConn) Write(b []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.Timeout))
+	return c.Conn.Write(b)
+}
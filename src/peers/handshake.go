@@ -54,10 +54,11 @@ func PerformHandshake(peer parser.Peer, infoHash []byte, peerId []byte, download
 	dest := net.JoinHostPort(peer.Ip.String(), strconv.FormatUint(uint64(peer.Port), 10))
 	// fmt.Println("Connecting to", dest)
 
-	conn, err := net.DialTimeout("tcp", dest, HANDSHAKE_TIMEOUT*time.Second)
+	raw, err := net.DialTimeout("tcp", dest, HANDSHAKE_TIMEOUT*time.Second)
 	if err != nil {
 		return nil, err
 	}
+	conn := &IdleConn{Conn: raw, Timeout: HANDSHAKE_TIMEOUT * time.Second}
 
 	// Send handshake
 	handshake := buildHandshake(infoHash, peerId)
@@ -88,20 +89,29 @@ func PerformHandshake(peer parser.Peer, infoHash []byte, peerId []byte, download
 	if downloaded.GetPieceCount() > 0 {
 		err := SendBitfield(downloaded.GetContent(), conn)
 		if err != nil {
+			conn.Close()
 			return nil, err
 		}
 	}
 
 	// Get bitfield message
 	msg, err := AwaitResponse(conn, 5)
-	if err != nil || msg[len(msg)-1] != 5 {
+	if err != nil {
+		conn.Close()
 		return nil, err
 	}
+	if msg[len(msg)-1] != 5 {
+		conn.Close()
+		return nil, fmt.Errorf("expected a bitfield, got message %d", msg[len(msg)-1])
+	}
 
 	bitf, err := AwaitResponse(conn, ReadLength(msg))
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
+	// past the handshake the peer only has to send something (a keep-alive at least) every IDLE_TIMEOUT
+	conn.Timeout = IDLE_TIMEOUT
 
 	// fmt.Println("Connected to peer", peer.Ip)
 	return &parser.Peer{Ip: peer.Ip, Port: peer.Port, Conn: conn, PeerId: [20]byte(resp[48:]), Bitfield: bitf}, nil
@@ -3,10 +3,13 @@ package peers
 import (
 	crand "crypto/rand"
 	"encoding/binary"
+	"fmt"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
 	"torrent-client/src/parser"
 )
 
@@ -14,6 +17,8 @@ const PORT = 6881
 const INIT = "BT"
 const VERSION = "0003"
 const NUM_PEERS = 50
+const IPV4_PROBE = "198.41.0.4:53"            // any public address, only used to find the local address routed to it
+const IPV6_PROBE = "[2001:503:ba3e::2:30]:53" // nothing is sent to them
 const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
 type ConnectionRequest struct {
@@ -33,6 +38,54 @@ type HttpConnection struct {
 	peerId string
 }
 
+// Event is the announce event, the values are the ones of the UDP protocol (BEP 15)
+type Event uint32
+
+const (
+	EVENT_NONE      Event = 0
+	EVENT_COMPLETED Event = 1
+	EVENT_STARTED   Event = 2
+	EVENT_STOPPED   Event = 3
+)
+
+// String is the value of the event parameter of HTTP announces
+func (e Event) String() string {
+	switch e {
+	case EVENT_COMPLETED:
+		return "completed"
+	case EVENT_STARTED:
+		return "started"
+	case EVENT_STOPPED:
+		return "stopped"
+	}
+	return ""
+}
+
+// ParseEvent reads the event parameter of an HTTP announce, "empty" and "" being no event
+func ParseEvent(s string) (Event, error) {
+	switch s {
+	case "", "empty":
+		return EVENT_NONE, nil
+	case "completed":
+		return EVENT_COMPLETED, nil
+	case "started":
+		return EVENT_STARTED, nil
+	case "stopped":
+		return EVENT_STOPPED, nil
+	}
+	return EVENT_NONE, fmt.Errorf("unknown event %q", s)
+}
+
+// AnnounceParams is what an announce reports to the tracker
+type AnnounceParams struct {
+	Event      Event
+	Uploaded   uint64
+	Downloaded uint64
+	Left       uint64
+	Key        uint32          // set by the tracker, 0 sends none
+	Cancel     <-chan struct{} // closing it abandons the announce, nil never does
+}
+
 type AnnounceRequest struct {
 	ConnectionId  uint64
 	Action        uint32
@@ -58,7 +111,11 @@ type AnnounceResponse struct {
 	Peers         []parser.Peer
 }
 
+var peerIdOnce sync.Once
+
+// GetPeerId returns the peer id of this session, generated on first use
 func GetPeerId() string {
+	peerIdOnce.Do(func() { connection.peerId = generatePeerId() })
 	return connection.peerId
 }
 
@@ -71,13 +128,27 @@ func generatePeerId() string {
 	return prefix + string(b)
 }
 
+// localAddr returns the public address this host uses for network, or nil if it has none
+func localAddr(network string, probe string) net.IP {
+	conn, err := net.Dial(network, probe) // connecting a UDP socket sends nothing
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	ip := conn.LocalAddr().(*net.UDPAddr).IP
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return nil
+	}
+	return ip
+}
+
 func generateTransactionId() uint32 {
 	var b [4]byte
 	crand.Read(b[:])
 	return binary.BigEndian.Uint32(b[:])
 }
 
-func buildTrackerUrl(trakerAddr string, infoHash []byte, totalLength uint64, connection *HttpConnection) (string, error) {
+func buildTrackerUrl(trakerAddr string, infoHash []byte, params AnnounceParams, trackerId string) (string, error) {
 	u, err := url.Parse(trakerAddr)
 	if err != nil {
 		return "", err
@@ -85,19 +156,35 @@ func buildTrackerUrl(trakerAddr string, infoHash []byte, totalLength uint64, con
 
 	q := url.Values{
 		"info_hash":  []string{string(infoHash[:])},
-		"peer_id":    []string{connection.peerId[:]},
+		"peer_id":    []string{GetPeerId()},
 		"port":       []string{strconv.Itoa(PORT)},
-		"uploaded":   []string{"0"},
-		"downloaded": []string{"0"},
-		"left":       []string{strconv.FormatUint(totalLength, 10)},
-		"conpact":    []string{"1"},
+		"uploaded":   []string{strconv.FormatUint(params.Uploaded, 10)},
+		"downloaded": []string{strconv.FormatUint(params.Downloaded, 10)},
+		"left":       []string{strconv.FormatUint(params.Left, 10)},
+		"compact":    []string{"1"},
+	}
+	if params.Event != EVENT_NONE {
+		q.Set("event", params.Event.String())
+	}
+	if trackerId != "" {
+		q.Set("trackerid", trackerId)
+	}
+	if params.Key != 0 {
+		q.Set("key", fmt.Sprintf("%08x", params.Key))
+	}
+	// tell the tracker both our addresses so peers of either family can find us (BEP 7)
+	if ip := localAddr("udp4", IPV4_PROBE); ip != nil {
+		q.Set("ipv4", ip.String())
+	}
+	if ip := localAddr("udp6", IPV6_PROBE); ip != nil {
+		q.Set("ipv6", ip.String())
 	}
 	u.RawQuery = q.Encode()
 
 	return u.String(), nil
 }
 
-func buildAnnounceRequest(connID uint64, txID uint32, infoHash, peerID []byte, totalSize uint64) []byte {
+func buildAnnounceRequest(connID uint64, txID uint32, infoHash, peerID []byte, params AnnounceParams) []byte {
 	buf := make([]byte, 98)
 	binary.BigEndian.PutUint64(buf[0:8], connID)
 	binary.BigEndian.PutUint32(buf[8:12], 1) // action = announce
@@ -106,24 +193,23 @@ func buildAnnounceRequest(connID uint64, txID uint32, infoHash, peerID []byte, t
 	copy(buf[16:36], infoHash)
 	copy(buf[36:56], peerID)
 
-	// downloaded, left, uploaded
-	binary.BigEndian.PutUint64(buf[56:64], 0)
-	binary.BigEndian.PutUint64(buf[64:72], totalSize)
-	binary.BigEndian.PutUint64(buf[72:80], 0)
+	binary.BigEndian.PutUint64(buf[56:64], params.Downloaded)
+	binary.BigEndian.PutUint64(buf[64:72], params.Left)
+	binary.BigEndian.PutUint64(buf[72:80], params.Uploaded)
 
-	binary.BigEndian.PutUint32(buf[80:84], 2)             // event = started
-	binary.BigEndian.PutUint32(buf[84:88], 0)             // ip = default
-	binary.BigEndian.PutUint32(buf[88:92], rand.Uint32()) // key
-	binary.BigEndian.PutUint32(buf[92:96], 0xFFFFFFFF)            // num_want (50 peers)
-	binary.BigEndian.PutUint16(buf[96:98], uint16(6881))  // port
+	binary.BigEndian.PutUint32(buf[80:84], uint32(params.Event))
+	binary.BigEndian.PutUint32(buf[84:88], 0)            // ip = default
+	binary.BigEndian.PutUint32(buf[88:92], params.Key)   // key
+	binary.BigEndian.PutUint32(buf[92:96], 0xFFFFFFFF)   // num_want = -1, as many as the tracker gives by default
+	binary.BigEndian.PutUint16(buf[96:98], uint16(6881)) // port
 
 	return buf
 }
 
 func makeConnectRequest(txID uint32) []byte {
 	buf := make([]byte, 16)
-	binary.BigEndian.PutUint64(buf[0:8], 0x41727101980) // protocol magic constant
-	binary.BigEndian.PutUint32(buf[8:12], 0)            // action = connect
+	binary.BigEndian.PutUint64(buf[0:8], PROTOCOL_ID)
+	binary.BigEndian.PutUint32(buf[8:12], 0) // action = connect
 	binary.BigEndian.PutUint32(buf[12:16], txID)
 	return buf
 }
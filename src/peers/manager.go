@@ -0,0 +1,325 @@
+package peers
+
+import (
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+	"torrent-client/src/parser"
+)
+
+/*
+Manager keeps a torrent's pool of candidate peers, whatever their source
+(trackers, resume data, ...), and keeps connections to the best of them
+open:
+
+- at most maxConns connections per torrent, of which at most maxHalfOpen
+  may still be connecting, and at most MAX_GLOBAL_CONNECTIONS /
+  MAX_GLOBAL_HALF_OPEN over all torrents of the process
+- a peer that failed is retried after RETRY_DELAY * 2^failures (capped at
+  MAX_RETRY_DELAY) and forgotten after MAX_FAILURES failures in a row
+- candidates are tried in order of their score, which goes up with every
+  successful connection and every MiB of good data and down with failures
+- a connection that ends frees its slot for the next candidate right away
+- when Run is stopped the established connections are closed, half-open
+  ones end with their handshake timeout
+
+For private torrents (BEP 27) RestrictSources limits the pool to the peers of
+the torrent's own trackers.
+*/
+
+const MAX_GLOBAL_CONNECTIONS = 200
+const MAX_GLOBAL_HALF_OPEN = 50
+const RETRY_DELAY = 15 * time.Second
+const MAX_RETRY_DELAY = 30 * time.Minute
+const RECONNECT_DELAY = time.Minute // before reconnecting to a peer that had nothing more to offer
+const MAX_FAILURES = 6
+
+var globalConns = make(chan struct{}, MAX_GLOBAL_CONNECTIONS)
+var globalHalfOpen = make(chan struct{}, MAX_GLOBAL_HALF_OPEN)
+
+type Candidate struct {
+	Peer        parser.Peer
+	Sources     []string // every source that reported the peer, first one first
+	Failures    int
+	Score       float64
+	NextAttempt time.Time
+	added       time.Time
+	active      bool
+}
+
+// Attempt is handed to the connect function to report how the connection is going
+type Attempt struct {
+	m           *Manager
+	c           *Candidate
+	established bool
+	conn        io.Closer
+}
+
+// ConnectFunc connects to a peer and only returns once the connection is over
+type ConnectFunc func(peer parser.Peer, a *Attempt) error
+
+// Filter reports whether a peer may be connected to
+type Filter func(peer parser.Peer) bool
+
+type Manager struct {
+	mu          sync.Mutex
+	candidates  map[string]*Candidate
+	filters     []Filter
+	sources     map[string]bool // nil accepts every source
+	connect     ConnectFunc
+	maxConns    int
+	maxHalfOpen int
+	connected   int
+	halfOpen    int
+	wake        chan struct{}
+	wg          sync.WaitGroup
+	live        map[*Attempt]bool // established connections
+	stopped     bool
+	now         func() time.Time // the clock, tests replace it
+}
+
+func PeerAddr(peer parser.Peer) string {
+	return net.JoinHostPort(peer.Ip.String(), strconv.Itoa(int(peer.Port)))
+}
+
+func NewManager(maxConns int, maxHalfOpen int, connect ConnectFunc) *Manager {
+	return &Manager{
+		candidates:  make(map[string]*Candidate),
+		connect:     connect,
+		maxConns:    maxConns,
+		maxHalfOpen: maxHalfOpen,
+		wake:        make(chan struct{}, 1),
+		live:        make(map[*Attempt]bool),
+		now:         time.Now,
+	}
+}
+
+func (m *Manager) AddFilter(f Filter) {
+	m.mu.Lock()
+	m.filters = append(m.filters, f)
+	m.mu.Unlock()
+}
+
+// RestrictSources makes Add drop the peers of any source not listed
+func (m *Manager) RestrictSources(sources []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sources = make(map[string]bool, len(sources))
+	for _, source := range sources {
+		m.sources[source] = true
+	}
+}
+
+/*
+Add puts peers into the pool, deduplicated by ip:port. Peers already in it
+keep their history and only get the source added to theirs.
+*/
+func (m *Manager) Add(peers []parser.Peer, source string) {
+	m.mu.Lock()
+	if m.sources != nil && !m.sources[source] {
+		m.mu.Unlock()
+		return
+	}
+	now := m.now()
+	for _, peer := range peers {
+		if peer.Ip == nil || peer.Ip.IsUnspecified() || peer.Port == 0 {
+			continue
+		}
+		addr := PeerAddr(peer)
+		if c, ok := m.candidates[addr]; ok {
+			if !slices.Contains(c.Sources, source) {
+				c.Sources = append(c.Sources, source)
+			}
+			continue
+		}
+		m.candidates[addr] = &Candidate{Peer: parser.Peer{Ip: peer.Ip, Port: peer.Port}, Sources: []string{source}, added: now}
+	}
+	m.mu.Unlock()
+	m.notify()
+}
+
+func (m *Manager) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Manager) allowed(peer parser.Peer) bool {
+	for _, f := range m.filters {
+		if !f(peer) {
+			return false
+		}
+	}
+	return true
+}
+
+// next picks the best candidate that may be tried now, the caller holds m.mu
+func (m *Manager) next(now time.Time) *Candidate {
+	var best *Candidate
+	for addr, c := range m.candidates {
+		if c.active || now.Before(c.NextAttempt) {
+			continue
+		}
+		if !m.allowed(c.Peer) {
+			delete(m.candidates, addr)
+			continue
+		}
+		if best == nil || c.Score > best.Score || (c.Score == best.Score && c.added.Before(best.added)) {
+			best = c
+		}
+	}
+	return best
+}
+
+func tryAcquire(sem chan struct{}) bool {
+	select {
+	case sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// fill starts connections until a limit is hit or no candidate is ready
+func (m *Manager) fill() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for m.connected+m.halfOpen < m.maxConns && m.halfOpen < m.maxHalfOpen {
+		c := m.next(now)
+		if c == nil {
+			return
+		}
+		if !tryAcquire(globalConns) {
+			return
+		}
+		if !tryAcquire(globalHalfOpen) {
+			<-globalConns
+			return
+		}
+
+		c.active = true
+		m.halfOpen++
+		m.wg.Add(1)
+		go m.run(c)
+	}
+}
+
+func (m *Manager) run(c *Candidate) {
+	defer m.wg.Done()
+	a := &Attempt{m: m, c: c}
+	err := m.connect(c.Peer, a)
+
+	m.mu.Lock()
+	if a.established {
+		m.connected--
+		delete(m.live, a)
+	} else {
+		m.halfOpen--
+		<-globalHalfOpen
+	}
+	<-globalConns
+
+	c.active = false
+	if err != nil && !a.established {
+		c.Failures++
+		c.Score -= 2
+		if c.Failures >= MAX_FAILURES {
+			delete(m.candidates, PeerAddr(c.Peer))
+		} else {
+			c.NextAttempt = m.now().Add(min(RETRY_DELAY<<(c.Failures-1), MAX_RETRY_DELAY))
+		}
+	} else {
+		c.NextAttempt = m.now().Add(RECONNECT_DELAY)
+	}
+	m.mu.Unlock()
+	m.notify()
+}
+
+// Established tells the manager the handshake went through and the connection is no longer half-open, conn is closed when the manager stops
+func (a *Attempt) Established(conn io.Closer) {
+	a.m.mu.Lock()
+	defer a.m.mu.Unlock()
+	if a.established {
+		return
+	}
+	a.established = true
+	a.conn = conn
+	a.m.halfOpen--
+	a.m.connected++
+	a.m.live[a] = true
+	<-globalHalfOpen
+	if a.m.stopped {
+		conn.Close()
+	}
+	a.c.Failures = 0
+	a.c.Score++
+	a.m.notify()
+}
+
+// Credit raises the peer's score for good data it sent
+func (a *Attempt) Credit(bytes uint64) {
+	a.m.mu.Lock()
+	a.c.Score += float64(bytes) / (1024 * 1024)
+	a.m.mu.Unlock()
+}
+
+// Run keeps the connections filled until stop is closed, then closes the open ones and waits for them to end
+func (m *Manager) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		m.fill()
+		select {
+		case <-m.wake:
+		case <-ticker.C:
+		case <-stop:
+			m.mu.Lock()
+			m.stopped = true
+			for a := range m.live {
+				a.conn.Close()
+			}
+			m.mu.Unlock()
+			m.wg.Wait()
+			return
+		}
+	}
+}
+
+// Disconnect closes the established connections to the peers f rejects, e.g. after the filters changed, and returns how many
+func (m *Manager) Disconnect(f Filter) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	closed := 0
+	for a := range m.live {
+		if !f(a.c.Peer) {
+			a.conn.Close()
+			closed++
+		}
+	}
+	return closed
+}
+
+func (m *Manager) Candidates() []Candidate {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Candidate, 0, len(m.candidates))
+	for _, c := range m.candidates {
+		candidate := *c
+		candidate.Sources = slices.Clone(c.Sources)
+		list = append(list, candidate)
+	}
+	return list
+}
+
+// Connections returns the number of established and half-open connections
+func (m *Manager) Connections() (int, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.connected, m.halfOpen
+}
@@ -0,0 +1,238 @@
+package peers
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+	"torrent-client/src/parser"
+)
+
+// fakeConn is one connection of fakeDialer, the test ends it with Close or by sending its result
+type fakeConn struct {
+	peer   parser.Peer
+	a      *Attempt
+	result chan error
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *fakeConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+// fakeDialer hands every connection the manager starts to the test
+type fakeDialer struct {
+	conns chan *fakeConn
+}
+
+func newFakeDialer() *fakeDialer {
+	return &fakeDialer{conns: make(chan *fakeConn, 100)}
+}
+
+func (d *fakeDialer) connect(peer parser.Peer, a *Attempt) error {
+	c := &fakeConn{peer: peer, a: a, result: make(chan error, 1), closed: make(chan struct{})}
+	d.conns <- c
+	select {
+	case err := <-c.result:
+		return err
+	case <-c.closed:
+		return nil
+	}
+}
+
+// startedWait waits for n connections to start
+func (d *fakeDialer) startedWait(t *testing.T, n int) []*fakeConn {
+	t.Helper()
+	var conns []*fakeConn
+	for len(conns) < n {
+		select {
+		case c := <-d.conns:
+			conns = append(conns, c)
+		case <-time.After(time.Second):
+			t.Fatalf("%d of %d connections started", len(conns), n)
+		}
+	}
+	return conns
+}
+
+// fakeClock is a clock that only moves when the test says so
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func newTestManager(maxConns int, maxHalfOpen int) (*Manager, *fakeDialer, *fakeClock) {
+	d := newFakeDialer()
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := NewManager(maxConns, maxHalfOpen, d.connect)
+	m.now = clock.Now
+	return m, d, clock
+}
+
+func testPeer(i int) parser.Peer {
+	return parser.Peer{Ip: net.IPv4(10, 0, 0, byte(i)), Port: 6881}
+}
+
+// endAll ends every connection still running and waits for the manager to notice
+func endAll(m *Manager, conns []*fakeConn) {
+	for _, c := range conns {
+		c.Close()
+	}
+	m.wg.Wait()
+}
+
+func TestManagerLimits(t *testing.T) {
+	m, d, clock := newTestManager(3, 2)
+	for i := 1; i <= 10; i++ {
+		m.Add([]parser.Peer{testPeer(i)}, "tracker")
+		clock.Advance(time.Second)
+	}
+
+	m.fill()
+	conns := d.startedWait(t, 2)
+	if connected, halfOpen := m.Connections(); connected != 0 || halfOpen != 2 {
+		t.Errorf("%d connected and %d half-open, want 0 and 2", connected, halfOpen)
+	}
+
+	for _, c := range conns {
+		c.a.Established(c)
+	}
+	m.fill()
+	conns = append(conns, d.startedWait(t, 1)...)
+	m.fill()
+	if connected, halfOpen := m.Connections(); connected != 2 || halfOpen != 1 {
+		t.Errorf("%d connected and %d half-open, want 2 and 1", connected, halfOpen)
+	}
+	endAll(m, conns)
+}
+
+func TestManagerGlobalHalfOpenLimit(t *testing.T) {
+	m, d, _ := newTestManager(10, 10)
+	m.Add([]parser.Peer{testPeer(1), testPeer(2)}, "tracker")
+
+	// every other torrent's half-open slots in use
+	for range MAX_GLOBAL_HALF_OPEN - 1 {
+		globalHalfOpen <- struct{}{}
+	}
+	m.fill()
+	conns := d.startedWait(t, 1)
+	if _, halfOpen := m.Connections(); halfOpen != 1 {
+		t.Errorf("%d half-open connections, the global limit leaves room for 1", halfOpen)
+	}
+	for range MAX_GLOBAL_HALF_OPEN - 1 {
+		<-globalHalfOpen
+	}
+	endAll(m, conns)
+}
+
+func TestManagerBackoff(t *testing.T) {
+	m, d, clock := newTestManager(5, 5)
+	m.Add([]parser.Peer{testPeer(1)}, "tracker")
+
+	wait := RETRY_DELAY
+	for failure := 1; failure <= MAX_FAILURES; failure++ {
+		m.fill()
+		c := d.startedWait(t, 1)[0]
+		c.result <- errors.New("connection refused")
+		m.wg.Wait()
+
+		candidates := m.Candidates()
+		if failure == MAX_FAILURES {
+			if len(candidates) != 0 {
+				t.Errorf("the peer is still a candidate after %d failures", failure)
+			}
+			break
+		}
+		if next := candidates[0].NextAttempt.Sub(clock.Now()); next != wait {
+			t.Errorf("after %d failures the next attempt is in %s, want %s", failure, next, wait)
+		}
+
+		clock.Advance(wait - time.Second)
+		m.fill()
+		if _, halfOpen := m.Connections(); halfOpen != 0 {
+			t.Fatalf("retried %s early after %d failures", time.Second, failure)
+		}
+		clock.Advance(time.Second)
+		wait = min(2*wait, MAX_RETRY_DELAY)
+	}
+}
+
+func TestManagerOrder(t *testing.T) {
+	m, d, clock := newTestManager(1, 1)
+	for i := 1; i <= 3; i++ {
+		m.Add([]parser.Peer{testPeer(i)}, "tracker")
+		clock.Advance(time.Second)
+	}
+
+	// equal scores: the peer added first goes first
+	m.fill()
+	first := d.startedWait(t, 1)[0]
+	if !first.peer.Ip.Equal(testPeer(1).Ip) {
+		t.Fatalf("tried %s first, want the oldest candidate", first.peer.Ip)
+	}
+	first.result <- errors.New("refused")
+	m.wg.Wait()
+
+	// peer 2 sends good data and scores above peer 3
+	m.fill()
+	second := d.startedWait(t, 1)[0]
+	second.a.Established(second)
+	second.a.Credit(3 * 1024 * 1024)
+	second.Close()
+	m.wg.Wait()
+
+	m.fill()
+	third := d.startedWait(t, 1)[0]
+	if !third.peer.Ip.Equal(testPeer(3).Ip) {
+		t.Fatalf("tried %s, want peer 3 while the others wait", third.peer.Ip)
+	}
+	third.Close()
+	m.wg.Wait()
+
+	clock.Advance(MAX_RETRY_DELAY)
+	m.fill()
+	best := d.startedWait(t, 1)[0]
+	if !best.peer.Ip.Equal(testPeer(2).Ip) {
+		t.Errorf("tried %s, want peer 2 with the best score", best.peer.Ip)
+	}
+	endAll(m, []*fakeConn{best})
+}
+
+func TestManagerDisconnectFreesSlot(t *testing.T) {
+	m, d, _ := newTestManager(1, 1)
+	m.Add([]parser.Peer{testPeer(1), testPeer(2)}, "tracker")
+
+	m.fill()
+	c := d.startedWait(t, 1)[0]
+	c.a.Established(c)
+	blocked := c.peer
+	if n := m.Disconnect(func(p parser.Peer) bool { return !p.Ip.Equal(blocked.Ip) }); n != 1 {
+		t.Fatalf("disconnected %d peers, want 1", n)
+	}
+	m.wg.Wait()
+	if connected, halfOpen := m.Connections(); connected != 0 || halfOpen != 0 {
+		t.Errorf("%d connected and %d half-open after the disconnect", connected, halfOpen)
+	}
+
+	m.fill()
+	next := d.startedWait(t, 1)[0]
+	if next.peer.Ip.Equal(blocked.Ip) {
+		t.Error("the freed slot went to the disconnected peer")
+	}
+	endAll(m, []*fakeConn{next})
+}
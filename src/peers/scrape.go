@@ -0,0 +1,87 @@
+package peers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"torrent-client/src/parser"
+)
+
+/*
+Scrape asks a tracker for the swarm counts of torrents without announcing.
+
+HTTP trackers are scraped at the announce url with its last path component
+"announce" replaced by "scrape" (http://host/x/announce?a=b ->
+http://host/x/scrape?a=b), trackers whose announce url does not end that way
+do not support scrape. UDP trackers get a scrape request (action 2) with up
+to MAX_UDP_SCRAPE info hashes per packet.
+*/
+
+const MAX_UDP_SCRAPE = 74 // info hashes per UDP scrape packet (BEP 15)
+
+type ScrapeResult = parser.ScrapeFile
+
+// ScrapeURL derives the scrape url of an HTTP tracker from its announce url
+func ScrapeURL(announce string) (string, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return "", err
+	}
+	i := strings.LastIndex(u.Path, "/")
+	if i < 0 || !strings.HasPrefix(u.Path[i+1:], "announce") {
+		return "", fmt.Errorf("tracker %s does not support scrape", announce)
+	}
+	u.Path = u.Path[:i+1] + "scrape" + strings.TrimPrefix(u.Path[i+1:], "announce")
+	return u.String(), nil
+}
+
+// Scrape returns the counts the tracker has for each of the info hashes, hashes it does not know are missing; udp is the client for UDP trackers
+func Scrape(announce string, infoHashes [][20]byte, udp *UDPClient) (map[[20]byte]ScrapeResult, error) {
+	tracker, err := newTracker(announce, udp)
+	if err != nil {
+		return nil, err
+	}
+	return tracker.Scrape(infoHashes)
+}
+
+func (h *HTTPTracker) Scrape(infoHashes [][20]byte) (map[[20]byte]ScrapeResult, error) {
+	scrape, err := ScrapeURL(h.URL())
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(scrape)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	for _, hash := range infoHashes {
+		q.Add("info_hash", string(hash[:]))
+	}
+	u.RawQuery = q.Encode()
+
+	body, err := HTTPRequest(u.String(), &connection, nil)
+	if err != nil {
+		return nil, err
+	}
+	r := parser.NewReader(body)
+	res, err := r.DecodeScrapeResponse()
+	if err != nil {
+		return nil, &ProtocolError{Err: err}
+	}
+	if res.FailureReason != "" {
+		return nil, &FailureError{Reason: res.FailureReason}
+	}
+	return res.Files, nil
+}
+
+func buildScrapeRequest(connID uint64, txID uint32, infoHashes [][20]byte) []byte {
+	buf := make([]byte, 16+20*len(infoHashes))
+	binary.BigEndian.PutUint64(buf[0:8], connID)
+	binary.BigEndian.PutUint32(buf[8:12], 2) // action = scrape
+	binary.BigEndian.PutUint32(buf[12:16], txID)
+	for i, hash := range infoHashes {
+		copy(buf[16+20*i:], hash[:])
+	}
+	return buf
+}
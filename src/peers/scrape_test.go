@@ -0,0 +1,31 @@
+package peers
+
+import "testing"
+
+func TestScrapeURL(t *testing.T) {
+	tests := []struct {
+		announce string
+		want     string // empty when the tracker does not support scrape
+	}{
+		{"http://example.com/announce", "http://example.com/scrape"},
+		{"http://example.com/x/announce", "http://example.com/x/scrape"},
+		{"http://example.com/announce.php", "http://example.com/scrape.php"},
+		{"http://example.com:6969/announce?passkey=abc", "http://example.com:6969/scrape?passkey=abc"},
+		{"https://example.com/announce/", ""}, // the last component is empty
+		{"http://example.com/a", ""},
+		{"http://example.com/x/announce/y", ""},
+		{"http://example.com", ""},
+	}
+	for _, tt := range tests {
+		got, err := ScrapeURL(tt.announce)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: got %s, want no scrape url", tt.announce, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q (%v), want %q", tt.announce, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package peers
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"torrent-client/src/parser"
+)
+
+/*
+Tracker is one announce url of a torrent. The HTTP and UDP implementations
+never exit the process, they return one of these errors (possibly wrapped,
+check with errors.Is / errors.As) or a network error:
+
+- ErrTimeout: the tracker did not answer in time
+- ErrCanceled: the Cancel channel of the request was closed
+- *FailureError: the tracker answered and refused the request
+- *ProtocolError: the answer is not what the protocol says
+
+and remember how their last announce went in their TrackerStatus.
+
+Every tracker gets a random key when it is created and sends it with each of
+its announces along with the peer id of the session, neither changes between
+announces. Private trackers (BEP 27) rely on them to tell a client whose
+address changed from someone announcing in its name.
+*/
+
+var ErrTimeout = errors.New("tracker did not respond in time")
+
+// FailureError is a tracker refusing a request, Reason is its failure reason
+type FailureError struct {
+	Reason string
+}
+
+func (e *FailureError) Error() string {
+	return "tracker failure: " + e.Reason
+}
+
+// ProtocolError is a malformed or unexpected tracker response
+type ProtocolError struct {
+	Err error
+}
+
+func (e *ProtocolError) Error() string {
+	return "tracker protocol error: " + e.Err.Error()
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+func protocolError(format string, a ...any) error {
+	return &ProtocolError{Err: fmt.Errorf(format, a...)}
+}
+
+// timeoutError turns network timeouts into ErrTimeout
+func timeoutError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+	return err
+}
+
+type TrackerStatus struct {
+	URL          string
+	LastAnnounce time.Time // zero before the first announce
+	NextAnnounce time.Time
+	LastError    error // of the last announce, nil when it succeeded
+	Peers        int   // returned by the last successful announce
+	Seeders      uint32
+	Leechers     uint32
+}
+
+func (s TrackerStatus) String() string {
+	if s.LastAnnounce.IsZero() {
+		return fmt.Sprintf("%s: not announced yet", s.URL)
+	}
+	next := "-"
+	if !s.NextAnnounce.IsZero() {
+		next = time.Until(s.NextAnnounce).Round(time.Second).String()
+	}
+	if s.LastError != nil {
+		return fmt.Sprintf("%s: error %v | next announce in %s", s.URL, s.LastError, next)
+	}
+	return fmt.Sprintf("%s: %d peers, %d seeders, %d leechers | announced %s ago, next in %s",
+		s.URL, s.Peers, s.Seeders, s.Leechers, time.Since(s.LastAnnounce).Round(time.Second), next)
+}
+
+type Tracker interface {
+	URL() string
+	Announce(t *parser.Torrent, params AnnounceParams) (*parser.Response, error)
+	Scrape(infoHashes [][20]byte) (map[[20]byte]ScrapeResult, error)
+	Status() TrackerStatus
+	// SetNextAnnounce records when the caller will announce next
+	SetNextAnnounce(next time.Time)
+}
+
+// NewTracker returns the implementation for the scheme of the announce url, UDP trackers use DefaultUDPClient
+func NewTracker(announce string) (Tracker, error) {
+	return newTracker(announce, DefaultUDPClient)
+}
+
+func newTracker(announce string, udp *UDPClient) (Tracker, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracker url %s: %w", announce, err)
+	}
+	switch {
+	case u.Scheme == "http" || u.Scheme == "https":
+		return &HTTPTracker{trackerStatus: newTrackerStatus(announce)}, nil
+	case u.Scheme == "udp":
+		if u.Port() == "" {
+			return nil, fmt.Errorf("udp tracker url %s has no port", announce)
+		}
+		return &UDPTracker{trackerStatus: newTrackerStatus(announce), host: u.Hostname(), port: u.Port(), client: udp}, nil
+	}
+	return nil, fmt.Errorf("this is an unknown protocol %s", u.Scheme)
+}
+
+// trackerStatus is the bookkeeping both implementations share
+type trackerStatus struct {
+	mu     sync.Mutex
+	status TrackerStatus
+	key    uint32
+}
+
+func newTrackerStatus(announce string) trackerStatus {
+	return trackerStatus{status: TrackerStatus{URL: announce}, key: rand.Uint32() | 1} // never 0, which sends no key
+}
+
+func (s *trackerStatus) URL() string {
+	return s.status.URL
+}
+
+func (s *trackerStatus) Status() TrackerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+func (s *trackerStatus) SetNextAnnounce(next time.Time) {
+	s.mu.Lock()
+	s.status.NextAnnounce = next
+	s.mu.Unlock()
+}
+
+func (s *trackerStatus) record(res *parser.Response, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.LastAnnounce = time.Now()
+	s.status.LastError = err
+	if err == nil {
+		s.status.Peers = len(res.Peers)
+		s.status.Seeders = res.Complete
+		s.status.Leechers = res.Incomplete
+	}
+}
+
+type HTTPTracker struct {
+	trackerStatus
+	trackerId string // sent back on later announces
+}
+
+func (h *HTTPTracker) Announce(t *parser.Torrent, params AnnounceParams) (*parser.Response, error) {
+	res, err := h.announce(t, params)
+	h.record(res, err)
+	return res, err
+}
+
+func (h *HTTPTracker) announce(t *parser.Torrent, params AnnounceParams) (*parser.Response, error) {
+	params.Key = h.key
+	h.mu.Lock()
+	trackerId := h.trackerId
+	h.mu.Unlock()
+
+	trackerAddr, err := buildTrackerUrl(h.URL(), t.InfoHash, params, trackerId)
+	if err != nil {
+		return nil, err
+	}
+	body, err := HTTPRequest(trackerAddr, &connection, params.Cancel)
+	if err != nil {
+		return nil, err
+	}
+
+	r := parser.NewReader(body)
+	res, err := r.DecodeHttpResponse()
+	if err != nil {
+		return nil, &ProtocolError{Err: err}
+	}
+	if res.FailureReason != "" {
+		return nil, &FailureError{Reason: res.FailureReason}
+	}
+	if res.WarningMessage != "" {
+		fmt.Fprintf(os.Stderr, "Tracker %s warning: %s\n", h.URL(), res.WarningMessage)
+	}
+	if res.TrackerId != "" {
+		h.mu.Lock()
+		h.trackerId = res.TrackerId
+		h.mu.Unlock()
+	}
+	return res, nil
+}
+
+type UDPTracker struct {
+	trackerStatus
+	host   string
+	port   string
+	client *UDPClient
+}
+
+func (u *UDPTracker) Announce(t *parser.Torrent, params AnnounceParams) (*parser.Response, error) {
+	params.Key = u.key
+	res, err := u.announce(t.InfoHash, []byte(GetPeerId()), params)
+	u.record(res, err)
+	return res, err
+}
+
+/*
+announce announces over every address family the tracker has an address in
+(one IPv4 and one IPv6 address at most) and merges the answers. It only
+fails when no address family got an answer.
+*/
+func (u *UDPTracker) announce(infoHash []byte, peerId []byte, params AnnounceParams) (*parser.Response, error) {
+	port, err := strconv.Atoi(u.port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracker port %s", u.port)
+	}
+	ips, err := net.LookupIP(u.host)
+	if err != nil {
+		return nil, fmt.Errorf("resolve udp: %w", err)
+	}
+
+	var v4, v6 net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil && v4 == nil {
+			v4 = ip
+		} else if ip.To4() == nil && v6 == nil {
+			v6 = ip
+		}
+	}
+
+	// both families at once, a family without a working route would hold up the other one for the whole retransmission schedule
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var merged *parser.Response
+	var lastErr error
+	for _, ip := range []net.IP{v6, v4} {
+		if ip == nil {
+			continue
+		}
+		addr := &net.UDPAddr{IP: ip, Port: port}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := u.client.Announce(addr, u.URL(), infoHash, peerId, params)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				lastErr = err
+				return
+			}
+			if merged == nil {
+				merged = &parser.Response{Interval: res.Interval}
+			}
+			merged.Peers = append(merged.Peers, res.Peers...)
+			merged.Complete = max(merged.Complete, res.Seeders)
+			merged.Incomplete = max(merged.Incomplete, res.Leechers)
+		}()
+	}
+	wg.Wait()
+	if merged == nil {
+		return nil, lastErr
+	}
+	return merged, nil
+}
+
+func (u *UDPTracker) Scrape(infoHashes [][20]byte) (map[[20]byte]ScrapeResult, error) {
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(u.host, u.port))
+	if err != nil {
+		return nil, fmt.Errorf("resolve udp: %w", err)
+	}
+	return u.client.Scrape(addr, infoHashes)
+}
@@ -0,0 +1,356 @@
+package peers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+	"torrent-client/src/parser"
+)
+
+/*
+UDPClient talks to UDP trackers (BEP 15) for any number of torrents over a
+single socket:
+
+- responses are matched to their requests by transaction id, so requests to
+  different trackers and for different torrents run concurrently
+- a connection id is reused for CONNECTION_ID_LIFETIME after the tracker
+  handed it out, and fetched again when it expired
+- a request that gets no answer is retransmitted after 15 * 2^n seconds, n
+  going from 0 to Retries; a connect and the request it is for share n.
+  BEP 15 goes up to 8 (over 2 hours in total), DefaultUDPClient only to
+  UDP_RETRIES as the announcer has its own retry schedule and falls back to
+  other trackers
+- closing the cancel channel of a request abandons it with ErrCanceled
+- announces carry the path and query of the announce url as URL data (BEP 41)
+
+The socket is opened on first use and listens on both IPv4 and IPv6 where the
+system allows it.
+*/
+
+const UDP_TIMEOUT = 15 * time.Second
+const UDP_MAX_RETRIES = 8 // BEP 15, 15 * 2^8 seconds = 64 minutes for the last attempt
+const UDP_RETRIES = 2     // 15 + 30 + 60 seconds before a silent tracker counts as failed
+const CONNECTION_ID_LIFETIME = time.Minute
+const PROTOCOL_ID = 0x41727101980 // connection id of connect requests
+const MAX_UDP_PACKET = 65535
+
+const (
+	ACTION_CONNECT  = 0
+	ACTION_ANNOUNCE = 1
+	ACTION_SCRAPE   = 2
+	ACTION_ERROR    = 3
+)
+
+const (
+	OPTION_END_OF_OPTIONS = 0
+	OPTION_NOP            = 1
+	OPTION_URL_DATA       = 2
+)
+
+type connectionId struct {
+	id       uint64
+	obtained time.Time
+}
+
+type pendingRequest struct {
+	addr     string
+	response chan []byte
+}
+
+type UDPClient struct {
+	Retries int
+	Timeout time.Duration    // before the first retransmission, UDP_TIMEOUT; the later ones double it
+	now     func() time.Time // the clock of the connection ids, tests replace it
+
+	mu      sync.Mutex
+	conn    *net.UDPConn
+	pending map[uint32]pendingRequest
+	connIds map[string]connectionId // tracker address -> connection id
+}
+
+var ErrCanceled = errors.New("tracker request canceled")
+
+var DefaultUDPClient = NewUDPClient(UDP_RETRIES)
+
+// NewUDPClient returns a client that retransmits an unanswered request retries times, at most UDP_MAX_RETRIES
+func NewUDPClient(retries int) *UDPClient {
+	return &UDPClient{
+		Retries: min(retries, UDP_MAX_RETRIES),
+		Timeout: UDP_TIMEOUT,
+		now:     time.Now,
+		pending: make(map[uint32]pendingRequest),
+		connIds: make(map[string]connectionId),
+	}
+}
+
+// socket opens the shared socket and starts reading from it the first time it is needed
+func (c *UDPClient) socket() (*net.UDPConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, fmt.Errorf("open udp socket: %w", err)
+	}
+	c.conn = conn
+	go c.readLoop(conn)
+	return conn, nil
+}
+
+// readLoop hands every packet to the request with its transaction id
+func (c *UDPClient) readLoop(conn *net.UDPConn) {
+	buf := make([]byte, MAX_UDP_PACKET)
+	for {
+		n, from, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if c.closed(conn) {
+				return
+			}
+			continue
+		}
+		if n < 8 {
+			continue
+		}
+		txID := binary.BigEndian.Uint32(buf[4:8])
+
+		c.mu.Lock()
+		req, ok := c.pending[txID]
+		if ok && req.addr == addrKey(from.Addr().Unmap().String(), from.Port()) {
+			delete(c.pending, txID)
+			req.response <- append([]byte(nil), buf[:n]...)
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *UDPClient) closed(conn *net.UDPConn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != conn
+}
+
+func (c *UDPClient) Close() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+func addrKey(ip string, port uint16) string {
+	return net.JoinHostPort(ip, fmt.Sprint(port))
+}
+
+func udpAddrKey(addr *net.UDPAddr) string {
+	ip := addr.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return addrKey(ip.String(), uint16(addr.Port))
+}
+
+/*
+exchange sends a packet and waits timeout for the response with the same
+transaction id (bytes 12:16 of every request). It returns nil on a timeout.
+*/
+func (c *UDPClient) exchange(addr *net.UDPAddr, packet []byte, timeout time.Duration, cancel <-chan struct{}) ([]byte, error) {
+	conn, err := c.socket()
+	if err != nil {
+		return nil, err
+	}
+	txID := binary.BigEndian.Uint32(packet[12:16])
+	response := make(chan []byte, 1)
+
+	c.mu.Lock()
+	c.pending[txID] = pendingRequest{addr: udpAddrKey(addr), response: response}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, txID)
+		c.mu.Unlock()
+	}()
+
+	if _, err := conn.WriteToUDP(packet, addr); err != nil {
+		return nil, fmt.Errorf("write udp: %w", err)
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-response:
+		return res, nil
+	case <-timer.C:
+		return nil, nil
+	case <-cancel:
+		return nil, ErrCanceled
+	}
+}
+
+func (c *UDPClient) cachedConnectionId(addr *net.UDPAddr) (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cid, ok := c.connIds[udpAddrKey(addr)]
+	if !ok || c.now().Sub(cid.obtained) >= CONNECTION_ID_LIFETIME {
+		return 0, false
+	}
+	return cid.id, true
+}
+
+// forget drops a connection id the tracker did not accept
+func (c *UDPClient) forget(addr *net.UDPAddr) {
+	c.mu.Lock()
+	delete(c.connIds, udpAddrKey(addr))
+	c.mu.Unlock()
+}
+
+func checkAction(res []byte, expected uint32) error {
+	action := binary.BigEndian.Uint32(res[0:4])
+	if action == ACTION_ERROR {
+		return &FailureError{Reason: string(res[8:])}
+	}
+	if action != expected {
+		return protocolError("bad response (action=%d, expected %d)", action, expected)
+	}
+	return nil
+}
+
+/*
+request runs one request with the retransmission schedule, connecting first
+whenever there is no valid connection id. build creates the request for a
+connection id and a transaction id, the response is checked to be of action.
+*/
+func (c *UDPClient) request(addr *net.UDPAddr, action uint32, cancel <-chan struct{}, build func(connID uint64, txID uint32) []byte) ([]byte, error) {
+	for n := 0; n <= c.Retries; n++ {
+		select {
+		case <-cancel:
+			return nil, ErrCanceled
+		default:
+		}
+		timeout := c.Timeout << n
+
+		connID, ok := c.cachedConnectionId(addr)
+		if !ok {
+			res, err := c.exchange(addr, makeConnectRequest(generateTransactionId()), timeout, cancel)
+			if err != nil {
+				return nil, err
+			}
+			if res == nil {
+				continue
+			}
+			if err := checkAction(res, ACTION_CONNECT); err != nil {
+				return nil, err
+			}
+			if len(res) < 16 {
+				return nil, protocolError("connect response too short: got %d bytes", len(res))
+			}
+			connID = binary.BigEndian.Uint64(res[8:16])
+			c.mu.Lock()
+			c.connIds[udpAddrKey(addr)] = connectionId{id: connID, obtained: c.now()}
+			c.mu.Unlock()
+		}
+
+		res, err := c.exchange(addr, build(connID, generateTransactionId()), timeout, cancel)
+		if err != nil {
+			return nil, err
+		}
+		if res == nil {
+			// the tracker may have dropped the request for an expired connection id
+			c.forget(addr)
+			continue
+		}
+		if err := checkAction(res, action); err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("%w: no response from %s after %d retransmissions", ErrTimeout, addr, c.Retries)
+}
+
+// urlData is the path and query of an announce url, sent along with UDP announces (BEP 41)
+func urlData(announce string) string {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return ""
+	}
+	return u.RequestURI()
+}
+
+// appendURLData appends the URL data option, split into chunks of at most 255 bytes, and the end of options
+func appendURLData(buf []byte, data string) []byte {
+	if data == "" || data == "/" {
+		return buf
+	}
+	for len(data) > 0 {
+		chunk := data[:min(len(data), 255)]
+		data = data[len(chunk):]
+		buf = append(buf, OPTION_URL_DATA, byte(len(chunk)))
+		buf = append(buf, chunk...)
+	}
+	return append(buf, OPTION_END_OF_OPTIONS)
+}
+
+// Announce announces to the tracker at addr, the peers come in the address family of the tracker
+func (c *UDPClient) Announce(addr *net.UDPAddr, announce string, infoHash []byte, peerId []byte, params AnnounceParams) (*AnnounceResponse, error) {
+	res, err := c.request(addr, ACTION_ANNOUNCE, params.Cancel, func(connID uint64, txID uint32) []byte {
+		return appendURLData(buildAnnounceRequest(connID, txID, infoHash, peerId, params), urlData(announce))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(res) < 20 {
+		return nil, protocolError("announce response too short: got %d bytes", len(res))
+	}
+
+	ipLen := net.IPv6len
+	if addr.IP.To4() != nil {
+		ipLen = net.IPv4len
+	}
+	peers, err := parser.DecodeCompactPeers(res[20:], ipLen)
+	if err != nil {
+		return nil, &ProtocolError{Err: err}
+	}
+	return &AnnounceResponse{
+		Action:        ACTION_ANNOUNCE,
+		TransactionId: binary.BigEndian.Uint32(res[4:8]),
+		Interval:      binary.BigEndian.Uint32(res[8:12]),
+		Leechers:      binary.BigEndian.Uint32(res[12:16]),
+		Seeders:       binary.BigEndian.Uint32(res[16:20]),
+		Peers:         peers,
+	}, nil
+}
+
+// Scrape asks the tracker at addr for the counts of the info hashes, MAX_UDP_SCRAPE per request
+func (c *UDPClient) Scrape(addr *net.UDPAddr, infoHashes [][20]byte) (map[[20]byte]ScrapeResult, error) {
+	results := make(map[[20]byte]ScrapeResult)
+	for start := 0; start < len(infoHashes); start += MAX_UDP_SCRAPE {
+		batch := infoHashes[start:min(start+MAX_UDP_SCRAPE, len(infoHashes))]
+		res, err := c.request(addr, ACTION_SCRAPE, nil, func(connID uint64, txID uint32) []byte {
+			return buildScrapeRequest(connID, txID, batch)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// seeders, completed, leechers for each hash, in the order they were asked for
+		for i, hash := range batch {
+			if 8+12*(i+1) > len(res) {
+				break
+			}
+			entry := res[8+12*i:]
+			results[hash] = ScrapeResult{
+				Complete:   binary.BigEndian.Uint32(entry[0:4]),
+				Downloaded: binary.BigEndian.Uint32(entry[4:8]),
+				Incomplete: binary.BigEndian.Uint32(entry[8:12]),
+			}
+		}
+	}
+	return results, nil
+}
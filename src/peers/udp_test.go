@@ -0,0 +1,192 @@
+package peers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUDPTracker records every packet it gets and answers connects and announces unless silent
+type fakeUDPTracker struct {
+	conn   *net.UDPConn
+	silent bool
+
+	mu       sync.Mutex
+	actions  []uint32
+	arrivals []time.Time
+}
+
+func newFakeUDPTracker(t *testing.T, silent bool) *fakeUDPTracker {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeUDPTracker{conn: conn, silent: silent}
+	t.Cleanup(func() { conn.Close() })
+	go f.serve()
+	return f
+}
+
+const fakeConnectionId = 0x1234
+
+func (f *fakeUDPTracker) serve() {
+	buf := make([]byte, MAX_UDP_PACKET)
+	for {
+		n, from, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		action := binary.BigEndian.Uint32(buf[8:12])
+		f.mu.Lock()
+		f.actions = append(f.actions, action)
+		f.arrivals = append(f.arrivals, time.Now())
+		f.mu.Unlock()
+		if f.silent || n < 16 {
+			continue
+		}
+
+		res := binary.BigEndian.AppendUint32(nil, action)
+		res = append(res, buf[12:16]...)
+		switch action {
+		case ACTION_CONNECT:
+			res = binary.BigEndian.AppendUint64(res, fakeConnectionId)
+		case ACTION_ANNOUNCE:
+			if binary.BigEndian.Uint64(buf[0:8]) != fakeConnectionId {
+				continue
+			}
+			res = append(res, make([]byte, 12)...) // interval, leechers, seeders
+		}
+		f.conn.WriteToUDP(res, from)
+	}
+}
+
+func (f *fakeUDPTracker) addr() *net.UDPAddr {
+	return f.conn.LocalAddr().(*net.UDPAddr)
+}
+
+func (f *fakeUDPTracker) received() ([]uint32, []time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]uint32(nil), f.actions...), append([]time.Time(nil), f.arrivals...)
+}
+
+func countAction(actions []uint32, action uint32) int {
+	n := 0
+	for _, a := range actions {
+		if a == action {
+			n++
+		}
+	}
+	return n
+}
+
+func TestUDPConnectionIdReuse(t *testing.T) {
+	tracker := newFakeUDPTracker(t, false)
+	client := NewUDPClient(1)
+	defer client.Close()
+	now := time.Now()
+	client.now = func() time.Time { return now }
+
+	announce := func() {
+		t.Helper()
+		if _, err := client.Announce(tracker.addr(), "udp://tracker/announce", make([]byte, 20), make([]byte, 20), AnnounceParams{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	announce()
+	now = now.Add(CONNECTION_ID_LIFETIME - time.Second)
+	announce()
+	actions, _ := tracker.received()
+	if connects := countAction(actions, ACTION_CONNECT); connects != 1 {
+		t.Errorf("%d connects for two announces within the lifetime of the id, want 1", connects)
+	}
+
+	now = now.Add(time.Second)
+	announce()
+	actions, _ = tracker.received()
+	if connects := countAction(actions, ACTION_CONNECT); connects != 2 {
+		t.Errorf("%d connects after the id expired, want 2", connects)
+	}
+	if announces := countAction(actions, ACTION_ANNOUNCE); announces != 3 {
+		t.Errorf("%d announces, want 3", announces)
+	}
+}
+
+func TestUDPRetransmitSchedule(t *testing.T) {
+	tracker := newFakeUDPTracker(t, true)
+	client := NewUDPClient(2)
+	client.Timeout = 50 * time.Millisecond
+	defer client.Close()
+
+	start := time.Now()
+	_, err := client.Announce(tracker.addr(), "udp://tracker/announce", make([]byte, 20), make([]byte, 20), AnnounceParams{})
+	elapsed := time.Since(start)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+
+	actions, arrivals := tracker.received()
+	if len(actions) != 3 || countAction(actions, ACTION_CONNECT) != 3 {
+		t.Fatalf("got actions %v, want 3 connects", actions)
+	}
+	// 50, 100 and 200ms of waiting
+	for i, want := range []time.Duration{50 * time.Millisecond, 100 * time.Millisecond} {
+		if gap := arrivals[i+1].Sub(arrivals[i]); gap < want || gap > want+want/2+20*time.Millisecond {
+			t.Errorf("retransmission %d after %s, want %s", i+1, gap, want)
+		}
+	}
+	if elapsed < 350*time.Millisecond {
+		t.Errorf("gave up after %s, want 350ms", elapsed)
+	}
+}
+
+func TestAppendURLData(t *testing.T) {
+	long := "/" + strings.Repeat("a", 599) // 600 bytes
+	tests := []struct {
+		name   string
+		data   string
+		chunks []string // nil when the option is left out
+	}{
+		{"no path", "", nil},
+		{"root path", "/", nil},
+		{"path and query", "/announce?passkey=x", []string{"/announce?passkey=x"}},
+		{"exactly one chunk", long[:255], []string{long[:255]}},
+		{"split", long, []string{long[:255], long[255:510], long[510:]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := appendURLData([]byte{9}, tt.data)
+			want := []byte{9}
+			for _, chunk := range tt.chunks {
+				want = append(want, OPTION_URL_DATA, byte(len(chunk)))
+				want = append(want, chunk...)
+			}
+			if tt.chunks != nil {
+				want = append(want, OPTION_END_OF_OPTIONS)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestURLData(t *testing.T) {
+	tests := map[string]string{
+		"udp://tracker:6969/announce?passkey=x": "/announce?passkey=x",
+		"udp://tracker:6969/dir/announce":       "/dir/announce",
+		"udp://tracker:6969":                    "/",
+	}
+	for announce, want := range tests {
+		if got := urlData(announce); got != want {
+			t.Errorf("%s: got %q, want %q", announce, got, want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// Conn throttles a peer connection with the limits of every level it belongs to
+type Conn struct {
+	net.Conn
+	Peer *Limits // the connection's own limits when created by WrapPeer
+	down []*Limiter
+	up   []*Limiter
+}
+
+func Wrap(conn net.Conn, levels ...*Limits) *Conn {
+	c := &Conn{Conn: conn}
+	for _, level := range levels {
+		if level != nil {
+			c.down = append(c.down, level.Down)
+			c.up = append(c.up, level.Up)
+		}
+	}
+	return c
+}
+
+// Read accounts for the bytes after reading them, the kernel's receive window slows down the sender meanwhile
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		WaitAll(n, c.down...)
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	WaitAll(len(b), c.up...)
+	return c.Conn.Write(b)
+}
+
+// TorrentLimits are a torrent's own limits plus the limits of each of its peer connections, changing PeerDown or PeerUp applies to the connections already open
+type TorrentLimits struct {
+	*Limits
+	PeerDown atomic.Int64
+	PeerUp   atomic.Int64
+}
+
+func NewTorrentLimits(down int64, up int64, peerDown int64, peerUp int64) *TorrentLimits {
+	t := &TorrentLimits{Limits: NewLimits(down, up)}
+	t.PeerDown.Store(peerDown)
+	t.PeerUp.Store(peerUp)
+	return t
+}
+
+// WrapPeer throttles a peer connection by the global, torrent and its own peer limits
+func (t *TorrentLimits) WrapPeer(conn net.Conn) *Conn {
+	peer := &Limits{Down: NewFollowingLimiter(&t.PeerDown), Up: NewFollowingLimiter(&t.PeerUp)}
+	c := Wrap(conn, Global, t.Limits, peer)
+	c.Peer = peer
+	return c
+}
@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Limiter is a token bucket: tokens (bytes) flow in at rate per second up to
+one second worth of burst. Taking more tokens than are available puts the
+bucket in debt, and whoever took them waits until the debt is paid off, so
+a 16 KiB block is never split up just to fit a small bucket.
+A rate of 0 means unlimited. A limiter made by NewFollowingLimiter reads its
+rate from a shared value on every reservation, so changing that value
+reaches every limiter following it.
+*/
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+	follow *atomic.Int64 // nil for a limiter with its own rate
+}
+
+// Limits holds the download and upload limiters of one level (global, torrent or peer)
+type Limits struct {
+	Down *Limiter
+	Up   *Limiter
+}
+
+// Global limits apply to all traffic of the process
+var Global = NewLimits(0, 0)
+
+func NewLimiter(rate int64) *Limiter {
+	return &Limiter{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// NewFollowingLimiter returns a limiter whose rate is whatever rate holds at the time
+func NewFollowingLimiter(rate *atomic.Int64) *Limiter {
+	l := NewLimiter(rate.Load())
+	l.follow = rate
+	return l
+}
+
+func NewLimits(down int64, up int64) *Limits {
+	return &Limits{Down: NewLimiter(down), Up: NewLimiter(up)}
+}
+
+// SetRate changes the rate in bytes per second, it takes effect for the next reservation
+func (l *Limiter) SetRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.setRate(time.Now(), float64(rate))
+}
+
+// setRate changes the rate, the caller holds l.mu
+func (l *Limiter) setRate(now time.Time, rate float64) {
+	l.refill(now)
+	l.rate = rate
+	l.tokens = min(l.tokens, l.rate)
+}
+
+func (l *Limiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.follow != nil {
+		return l.follow.Load()
+	}
+	return int64(l.rate)
+}
+
+// refill adds the tokens earned since the last call, the caller holds l.mu
+func (l *Limiter) refill(now time.Time) {
+	if l.rate > 0 {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.rate)
+	}
+	l.last = now
+}
+
+// reserve takes n tokens and returns how long the caller has to wait before using them
+func (l *Limiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.follow != nil {
+		if rate := float64(l.follow.Load()); rate != l.rate {
+			l.setRate(now, rate)
+		}
+	}
+	if l.rate <= 0 {
+		return 0
+	}
+	l.refill(now)
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+func (l *Limiter) Wait(n int) {
+	WaitAll(n, l)
+}
+
+// WaitAll takes n tokens from every limiter and waits for the slowest one
+func WaitAll(n int, limiters ...*Limiter) {
+	var wait time.Duration
+	for _, l := range limiters {
+		if l != nil {
+			wait = max(wait, l.reserve(n))
+		}
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimiterRefill(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    int64
+		tokens  float64
+		elapsed time.Duration
+		want    float64
+	}{
+		{"empty bucket half a second", 1000, 0, 500 * time.Millisecond, 500},
+		{"burst is one second worth", 1000, 0, 10 * time.Second, 1000},
+		{"full bucket stays full", 1000, 1000, time.Second, 1000},
+		{"debt is paid off first", 1000, -1500, time.Second, -500},
+		{"unlimited never refills", 0, 0, time.Second, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(tt.rate)
+			l.tokens = tt.tokens
+			l.refill(l.last.Add(tt.elapsed))
+			if l.tokens != tt.want {
+				t.Errorf("got %v tokens, want %v", l.tokens, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimiterReserve(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     int64
+		take     []int
+		wantLast time.Duration // wait of the last reservation, within 10ms
+	}{
+		{"within the burst", 1000, []int{400, 600}, 0},
+		{"a block larger than the bucket goes into debt", 1000, []int{1500}, 500 * time.Millisecond},
+		{"debt adds up", 1000, []int{1000, 1000}, time.Second},
+		{"unlimited", 0, []int{1 << 30}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(tt.rate)
+			var wait time.Duration
+			for _, n := range tt.take {
+				wait = l.reserve(n)
+			}
+			if d := wait - tt.wantLast; d < -10*time.Millisecond || d > 10*time.Millisecond {
+				t.Errorf("wait %s, want %s", wait, tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestFollowingLimiter(t *testing.T) {
+	var rate atomic.Int64
+	rate.Store(1000)
+	l := NewFollowingLimiter(&rate)
+
+	rate.Store(100)
+	// the bucket shrinks to the new burst, 100 tokens, before the reservation
+	if wait := l.reserve(200); wait < 900*time.Millisecond || wait > time.Second {
+		t.Errorf("wait %s after lowering the rate, want about 1s", wait)
+	}
+	if l.Rate() != 100 {
+		t.Errorf("rate %d, want 100", l.Rate())
+	}
+
+	rate.Store(0)
+	if wait := l.reserve(1 << 20); wait != 0 {
+		t.Errorf("wait %s once unlimited", wait)
+	}
+}
@@ -0,0 +1,156 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Schedule describes when the alternate ("office hours") limits apply, e.g.
+"mon-fri 09:00-17:00". A window ending before it starts wraps past midnight
+("22:00-06:00"), and leaving out the days means every day.
+*/
+type Schedule struct {
+	Days     [7]bool // indexed by time.Weekday
+	From     time.Duration
+	To       time.Duration
+	Down, Up int64
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %s, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func ParseSchedule(spec string, down int64, up int64) (*Schedule, error) {
+	s := Schedule{Down: down, Up: up}
+	fields := strings.Fields(strings.ToLower(spec))
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid schedule %q, expected [days] HH:MM-HH:MM", spec)
+	}
+
+	if len(fields) == 1 {
+		for i := range s.Days {
+			s.Days[i] = true
+		}
+	} else {
+		for _, part := range strings.Split(fields[0], ",") {
+			first, last, isRange := strings.Cut(part, "-")
+			if !isRange {
+				last = first
+			}
+			from, ok1 := weekdays[first]
+			to, ok2 := weekdays[last]
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("invalid days %q in schedule", part)
+			}
+			for d := from; ; d = (d + 1) % 7 {
+				s.Days[d] = true
+				if d == to {
+					break
+				}
+			}
+		}
+	}
+
+	window := fields[len(fields)-1]
+	fromStr, toStr, ok := strings.Cut(window, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid time window %q in schedule", window)
+	}
+	var err error
+	if s.From, err = parseClock(fromStr); err != nil {
+		return nil, err
+	}
+	if s.To, err = parseClock(toStr); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *Schedule) Active(now time.Time) bool {
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	if s.From <= s.To {
+		return s.Days[now.Weekday()] && clock >= s.From && clock < s.To
+	}
+	// the window wraps past midnight, the early morning part belongs to the previous day
+	if clock >= s.From {
+		return s.Days[now.Weekday()]
+	}
+	return clock < s.To && s.Days[(now.Weekday()+6)%7]
+}
+
+// Scheduler switches a level's limits between its normal rates and the schedule's
+type Scheduler struct {
+	mu       sync.Mutex
+	limits   *Limits
+	schedule *Schedule
+	down, up int64
+}
+
+func NewScheduler(limits *Limits, down int64, up int64, schedule *Schedule) *Scheduler {
+	s := &Scheduler{limits: limits, schedule: schedule, down: down, up: up}
+	s.Apply(time.Now())
+	return s
+}
+
+// Normal returns the limits used outside the schedule
+func (s *Scheduler) Normal() (down int64, up int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.down, s.up
+}
+
+// SetNormal changes the limits used outside the schedule
+func (s *Scheduler) SetNormal(down int64, up int64) {
+	s.mu.Lock()
+	s.down, s.up = down, up
+	s.mu.Unlock()
+	s.Apply(time.Now())
+}
+
+func (s *Scheduler) SetSchedule(schedule *Schedule) {
+	s.mu.Lock()
+	s.schedule = schedule
+	s.mu.Unlock()
+	s.Apply(time.Now())
+}
+
+func (s *Scheduler) Apply(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	down, up := s.down, s.up
+	if s.schedule != nil && s.schedule.Active(now) {
+		down, up = s.schedule.Down, s.schedule.Up
+	}
+	if s.limits.Down.Rate() != down {
+		s.limits.Down.SetRate(down)
+	}
+	if s.limits.Up.Rate() != up {
+		s.limits.Up.SetRate(up)
+	}
+}
+
+// Run applies the schedule every minute until stop is closed
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.Apply(now)
+		case <-stop:
+			return
+		}
+	}
+}
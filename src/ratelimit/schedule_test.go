@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// 2026-10-19 is a Monday
+func at(day int, clock string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", "2026-10-19 "+clock)
+	if err != nil {
+		panic(err)
+	}
+	return t.AddDate(0, 0, day)
+}
+
+func TestScheduleActive(t *testing.T) {
+	tests := []struct {
+		spec string
+		now  time.Time
+		want bool
+	}{
+		{"mon-fri 09:00-17:00", at(0, "09:00"), true},
+		{"mon-fri 09:00-17:00", at(0, "17:00"), false},
+		{"mon-fri 09:00-17:00", at(0, "08:59"), false},
+		{"mon-fri 09:00-17:00", at(5, "12:00"), false}, // saturday
+		{"09:00-17:00", at(6, "12:00"), true},
+		{"sat,sun 10:00-12:00", at(6, "11:00"), true},
+		{"fri-mon 00:00-23:59", at(2, "11:00"), false}, // wednesday, the range wraps past sunday
+		{"fri-mon 00:00-23:59", at(6, "11:00"), true},
+		{"mon 22:00-06:00", at(0, "23:00"), true},
+		{"mon 22:00-06:00", at(1, "05:00"), true}, // the night of monday
+		{"mon 22:00-06:00", at(0, "05:00"), false},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.spec, 0, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.spec, err)
+		}
+		if got := s.Active(tt.now); got != tt.want {
+			t.Errorf("%s at %s: got %v, want %v", tt.spec, tt.now.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{"", "mon", "mon-fri 9-17", "someday 09:00-17:00", "mon fri 09:00-17:00"} {
+		if _, err := ParseSchedule(spec, 0, 0); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
+
+func TestSchedulerSwitches(t *testing.T) {
+	schedule, err := ParseSchedule("mon-fri 09:00-17:00", 10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := NewLimits(0, 0)
+	s := NewScheduler(limits, 100, 200, schedule)
+
+	steps := []struct {
+		name     string
+		apply    func()
+		down, up int64
+	}{
+		{"office hours", func() { s.Apply(at(0, "10:00")) }, 10, 20},
+		{"evening", func() { s.Apply(at(0, "18:00")) }, 100, 200},
+		{"new normal limits", func() { s.SetNormal(300, 400); s.Apply(at(0, "18:00")) }, 300, 400},
+		{"office hours again", func() { s.Apply(at(1, "09:30")) }, 10, 20},
+		{"no schedule", func() { s.SetSchedule(nil); s.Apply(at(1, "09:30")) }, 300, 400},
+	}
+	for _, step := range steps {
+		step.apply()
+		if down, up := limits.Down.Rate(), limits.Up.Rate(); down != step.down || up != step.up {
+			t.Errorf("%s: limits %d/%d, want %d/%d", step.name, down, up, step.down, step.up)
+		}
+	}
+	if down, up := s.Normal(); down != 300 || up != 400 {
+		t.Errorf("normal limits %d/%d", down, up)
+	}
+}
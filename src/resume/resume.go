@@ -0,0 +1,200 @@
+package resume
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"torrent-client/src/parser"
+)
+
+/*
+Resume data is kept next to the downloaded data as
+.<hex info hash>.resume and holds everything needed to continue a download
+without rehashing it:
+{
+	info_hash: hex encoded info hash of the torrent
+	bitfield: pieces that were verified when the data was saved
+	files: size and mtime of every file backing a verified piece
+	uploaded, downloaded: transfer totals
+	peers: ip:port of peers we managed to connect to
+}
+*/
+
+const SAVE_INTERVAL = 30 * time.Second
+
+type FileState struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+}
+
+type Data struct {
+	InfoHash   string      `json:"info_hash"`
+	Bitfield   []byte      `json:"bitfield"`
+	Files      []FileState `json:"files"`
+	Uploaded   uint64      `json:"uploaded"`
+	Downloaded uint64      `json:"downloaded"`
+	Peers      []string    `json:"peers"`
+	SavedAt    int64       `json:"saved_at"`
+}
+
+// PieceFiles returns the paths, relative to the data directory, of the files holding a piece
+type PieceFiles func(pieceIndex uint32) []string
+
+func FilePath(outDir string, infoHash []byte) string {
+	return filepath.Join(outDir, "."+hex.EncodeToString(infoHash)+".resume")
+}
+
+func statFile(baseDir string, path string) (*FileState, error) {
+	info, err := os.Stat(filepath.Join(baseDir, path))
+	if err != nil {
+		return nil, err
+	}
+	return &FileState{Path: path, Size: info.Size(), ModTime: info.ModTime().UnixNano()}, nil
+}
+
+/*
+New captures the current state of a download. Pieces whose files cannot be
+stat'ed (not written to disk yet) are left out of the saved bitfield.
+*/
+func New(infoHash []byte, bitfield []byte, pieceCount uint32, baseDir string, pieceFiles PieceFiles, uploaded uint64, downloaded uint64, peers []parser.Peer) *Data {
+	d := Data{
+		InfoHash:   hex.EncodeToString(infoHash),
+		Bitfield:   make([]byte, len(bitfield)),
+		Uploaded:   uploaded,
+		Downloaded: downloaded,
+		SavedAt:    time.Now().Unix(),
+	}
+
+	seen := make(map[string]bool)
+	for i := range pieceCount {
+		dIndex, bit := i/8, byte(1<<(7-i%8))
+		if int(dIndex) >= len(bitfield) || bitfield[dIndex]&bit == 0 {
+			continue
+		}
+
+		var states []FileState
+		ok := true
+		for _, path := range pieceFiles(i) {
+			if seen[path] {
+				continue
+			}
+			state, err := statFile(baseDir, path)
+			if err != nil {
+				ok = false
+				break
+			}
+			states = append(states, *state)
+		}
+		if !ok {
+			continue
+		}
+
+		for _, state := range states {
+			seen[state.Path] = true
+		}
+		d.Files = append(d.Files, states...)
+		d.Bitfield[dIndex] |= bit
+	}
+
+	for _, peer := range peers {
+		d.Peers = append(d.Peers, net.JoinHostPort(peer.Ip.String(), strconv.FormatUint(uint64(peer.Port), 10)))
+	}
+	return &d
+}
+
+func Load(path string) (*Data, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var d Data
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return nil, fmt.Errorf("invalid resume data in %s: %w", path, err)
+	}
+	return &d, nil
+}
+
+// Save writes the resume data to a temporary file first so a crash never leaves a truncated file behind
+func (d *Data) Save(path string) error {
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return fmt.Errorf("failed to write resume data %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace resume data %s: %w", path, err)
+	}
+	return nil
+}
+
+func (d *Data) Matches(infoHash []byte) bool {
+	return d.InfoHash == hex.EncodeToString(infoHash)
+}
+
+/*
+Restore returns the saved bitfield with every piece whose files are missing
+or were modified since the data was saved cleared, so only those pieces have
+to be downloaded (or rehashed) again. The result always has room for
+pieceCount pieces, whatever the length of the saved bitfield.
+*/
+func (d *Data) Restore(pieceCount uint32, baseDir string, pieceFiles PieceFiles) []byte {
+	saved := make(map[string]FileState, len(d.Files))
+	for _, state := range d.Files {
+		saved[state.Path] = state
+	}
+
+	unchanged := make(map[string]bool)
+	bitfield := make([]byte, (pieceCount+7)/8)
+	for i := range pieceCount {
+		dIndex, bit := i/8, byte(1<<(7-i%8))
+		if int(dIndex) >= len(d.Bitfield) || d.Bitfield[dIndex]&bit == 0 {
+			continue
+		}
+
+		ok := true
+		for _, path := range pieceFiles(i) {
+			if same, checked := unchanged[path]; checked {
+				ok = ok && same
+				continue
+			}
+			state, err := statFile(baseDir, path)
+			prev, known := saved[path]
+			same := err == nil && known && *state == prev
+			unchanged[path] = same
+			ok = ok && same
+		}
+		if ok {
+			bitfield[dIndex] |= bit
+		}
+	}
+	return bitfield
+}
+
+func (d *Data) CachedPeers() []parser.Peer {
+	var peers []parser.Peer
+	for _, addr := range d.Peers {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		p, err := strconv.ParseUint(port, 10, 16)
+		ip := net.ParseIP(host)
+		if err != nil || ip == nil {
+			continue
+		}
+		peers = append(peers, parser.Peer{Ip: ip, Port: uint16(p)})
+	}
+	return peers
+}
@@ -0,0 +1,130 @@
+package resume
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+	"torrent-client/src/parser"
+)
+
+var testHash = []byte{0xab, 0xcd}
+
+// three pieces, one file each
+func pieceFiles(pieceIndex uint32) []string {
+	return []string{[]string{"a", "b", "c"}[pieceIndex]}
+}
+
+func writeFile(t *testing.T, dir string, name string, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// saved writes a and b, saves resume data for all three pieces and loads it back
+func saved(t *testing.T) (string, *Data) {
+	t.Helper()
+	dir := t.TempDir()
+	writeFile(t, dir, "a", "aaaa")
+	writeFile(t, dir, "b", "bbbb")
+
+	peers := []parser.Peer{{Ip: net.IPv4(10, 0, 0, 1), Port: 6881}, {Ip: net.ParseIP("2001:db8::1"), Port: 51413}}
+	d := New(testHash, []byte{0b1110_0000}, 3, dir, pieceFiles, 10, 20, peers)
+	if d.Bitfield[0] != 0b1100_0000 {
+		t.Errorf("saved bitfield %08b, the piece of the missing file c should be left out", d.Bitfield[0])
+	}
+
+	path := FilePath(dir, testHash)
+	if err := d.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, loaded
+}
+
+func TestSaveLoad(t *testing.T) {
+	_, d := saved(t)
+	if !d.Matches(testHash) || d.Matches([]byte{1}) {
+		t.Error("the info hash does not match")
+	}
+	if d.Uploaded != 10 || d.Downloaded != 20 || len(d.Files) != 2 {
+		t.Errorf("loaded %+v", d)
+	}
+	peers := d.CachedPeers()
+	if len(peers) != 2 || peers[1].Ip.String() != "2001:db8::1" || peers[1].Port != 51413 {
+		t.Errorf("peers %v", peers)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.resume")
+	writeFile(t, filepath.Dir(path), "bad.resume", "{not json")
+	if _, err := Load(path); err == nil {
+		t.Error("invalid resume data loaded")
+	}
+}
+
+func TestRestore(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, dir string)
+		want   byte
+	}{
+		{"unchanged", func(*testing.T, string) {}, 0b1100_0000},
+		{"size changed", func(t *testing.T, dir string) {
+			info, _ := os.Stat(filepath.Join(dir, "b"))
+			writeFile(t, dir, "b", "bbbbbb")
+			os.Chtimes(filepath.Join(dir, "b"), info.ModTime(), info.ModTime())
+		}, 0b1000_0000},
+		{"mtime changed", func(t *testing.T, dir string) {
+			later := time.Now().Add(time.Hour)
+			if err := os.Chtimes(filepath.Join(dir, "a"), later, later); err != nil {
+				t.Fatal(err)
+			}
+		}, 0b0100_0000},
+		{"file removed", func(t *testing.T, dir string) {
+			os.Remove(filepath.Join(dir, "a"))
+		}, 0b0100_0000},
+		{"file appeared since", func(t *testing.T, dir string) {
+			writeFile(t, dir, "c", "cccc")
+		}, 0b1100_0000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, d := saved(t)
+			tt.change(t, dir)
+			got := d.Restore(3, dir, pieceFiles)
+			if !slices.Equal(got, []byte{tt.want}) {
+				t.Errorf("restored %08b, want %08b", got[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreBadBitfield(t *testing.T) {
+	tests := []struct {
+		name     string
+		bitfield []byte
+		want     byte
+	}{
+		{"missing", nil, 0},
+		{"truncated", []byte{}, 0},
+		{"too long", []byte{0b1100_0000, 0xff, 0xff}, 0b1100_0000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, d := saved(t)
+			d.Bitfield = tt.bitfield
+			got := d.Restore(3, dir, pieceFiles)
+			if !slices.Equal(got, []byte{tt.want}) {
+				t.Errorf("restored %08b, want one byte %08b", got, tt.want)
+			}
+		})
+	}
+}
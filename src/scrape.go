@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"torrent-client/src/peers"
+)
+
+/*
+scrape => ./torrent-client scrape [file path]...
+Asks every tracker of the torrents how many seeders and leechers they have
+and how often they were completed, without joining the swarms. Torrents
+sharing a tracker are scraped together.
+*/
+func scrapeCommand(args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: ./torrent-client scrape [file path]...")
+		os.Exit(1)
+	}
+
+	// give up on a silent UDP tracker after 15 + 30 + 60 seconds like the announcer, not after the full hour of BEP 15
+	udp := peers.NewUDPClient(peers.UDP_RETRIES)
+	defer udp.Close()
+
+	var trackers []string
+	hashes := make(map[string][][20]byte) // tracker -> info hashes
+	names := make(map[[20]byte]string)
+	for _, path := range args {
+		t := readTorrent(path)
+		hash := [20]byte(t.InfoHash)
+		names[hash] = t.Info.Name
+		for _, url := range peers.Trackers(t) {
+			if _, ok := hashes[url]; !ok {
+				trackers = append(trackers, url)
+			}
+			hashes[url] = append(hashes[url], hash)
+		}
+	}
+
+	failed := 0
+	for _, url := range trackers {
+		fmt.Println(url)
+		results, err := peers.Scrape(url, hashes[url], udp)
+		if err != nil {
+			fmt.Printf("  error: %v\n", err)
+			failed++
+			continue
+		}
+		for _, hash := range hashes[url] {
+			res, ok := results[hash]
+			if !ok {
+				fmt.Printf("  %s: unknown to the tracker\n", names[hash])
+				continue
+			}
+			fmt.Printf("  %s: %d seeders, %d leechers, %d completed\n", names[hash], res.Complete, res.Incomplete, res.Downloaded)
+		}
+	}
+	if failed == len(trackers) {
+		os.Exit(1)
+	}
+}
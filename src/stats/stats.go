@@ -0,0 +1,295 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Torrent collects the transfer statistics of a torrent and of each of its
+peers. Payload is piece data, everything else sent or received on a peer
+connection (message headers, requests, haves, ...) counts as protocol
+overhead. Rates are exponential moving averages updated by Run once per
+TICK, so short bursts do not make them jump around.
+
+The CLI (and any other UI) polls Snapshot.
+*/
+
+const TICK = time.Second
+const RATE_WINDOW = 5 * time.Second // time constant of the moving average
+
+type counters struct {
+	payloadDown atomic.Uint64
+	payloadUp   atomic.Uint64
+	totalDown   atomic.Uint64 // payload + protocol
+	totalUp     atomic.Uint64
+}
+
+// rate is a smoothed bytes per second rate fed with a growing counter
+type rate struct {
+	value float64
+	prev  uint64
+}
+
+func (r *rate) update(current uint64, elapsed time.Duration) {
+	sample := float64(current-r.prev) / elapsed.Seconds()
+	alpha := 1 - math.Exp(-elapsed.Seconds()/RATE_WINDOW.Seconds())
+	r.value += alpha * (sample - r.value)
+	r.prev = current
+}
+
+type Peer struct {
+	counters
+	Addr     string
+	since    time.Time
+	downRate rate
+	upRate   rate
+}
+
+type Torrent struct {
+	counters
+	mu         sync.Mutex
+	total      uint64
+	completed  atomic.Uint64 // bytes of verified pieces
+	wasted     atomic.Uint64 // bytes of pieces that failed their hash check
+	prevDown   uint64        // payload totals of earlier sessions
+	prevUp     uint64
+	downRate   rate
+	upRate     rate
+	lastTick   time.Time
+	peers      map[string]*Peer
+	pieceCount uint32
+	piecesDone atomic.Uint32
+	now        func() time.Time // the clock, tests replace it
+}
+
+type PeerSnapshot struct {
+	Addr         string
+	Downloaded   uint64
+	Uploaded     uint64
+	DownloadRate float64 // bytes per second
+	UploadRate   float64
+	Connected    time.Duration
+}
+
+type Snapshot struct {
+	Total              uint64
+	Completed          uint64
+	Left               uint64
+	Progress           float64 // 0 to 1
+	PiecesDone         uint32
+	PieceCount         uint32
+	Downloaded         uint64 // payload, all sessions
+	Uploaded           uint64
+	ProtocolDownloaded uint64 // overhead, this session
+	ProtocolUploaded   uint64
+	Wasted             uint64
+	DownloadRate       float64 // payload bytes per second
+	UploadRate         float64
+	ETA                time.Duration // -1 when unknown
+	Ratio              float64
+	Peers              []PeerSnapshot
+}
+
+func NewTorrent(total uint64, pieceCount uint32) *Torrent {
+	return &Torrent{total: total, pieceCount: pieceCount, lastTick: time.Now(), peers: make(map[string]*Peer), now: time.Now}
+}
+
+// SetPrevious sets the payload totals of earlier sessions, e.g. from resume data
+func (t *Torrent) SetPrevious(downloaded uint64, uploaded uint64) {
+	t.mu.Lock()
+	t.prevDown, t.prevUp = downloaded, uploaded
+	t.mu.Unlock()
+}
+
+// SetCompleted sets the verified data, e.g. after a recheck
+func (t *Torrent) SetCompleted(bytes uint64, pieces uint32) {
+	t.completed.Store(bytes)
+	t.piecesDone.Store(pieces)
+}
+
+func (t *Torrent) PieceCompleted(length uint64) {
+	t.completed.Add(length)
+	t.piecesDone.Add(1)
+}
+
+func (t *Torrent) PieceFailed(length uint64) {
+	t.wasted.Add(length)
+}
+
+func (t *Torrent) AddPeer(addr string) *Peer {
+	p := &Peer{Addr: addr, since: t.now()}
+	t.mu.Lock()
+	t.peers[addr] = p
+	t.mu.Unlock()
+	return p
+}
+
+func (t *Torrent) RemovePeer(p *Peer) {
+	t.mu.Lock()
+	if t.peers[p.Addr] == p {
+		delete(t.peers, p.Addr)
+	}
+	t.mu.Unlock()
+}
+
+func (t *Torrent) Downloaded() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.prevDown + t.payloadDown.Load()
+}
+
+func (t *Torrent) Uploaded() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.prevUp + t.payloadUp.Load()
+}
+
+// Session returns the payload transferred since the client started, what trackers expect in announces
+func (t *Torrent) Session() (downloaded uint64, uploaded uint64) {
+	return t.payloadDown.Load(), t.payloadUp.Load()
+}
+
+func (t *Torrent) Left() uint64 {
+	return t.total - min(t.completed.Load(), t.total)
+}
+
+// PayloadDown records piece data received from a peer
+func (t *Torrent) PayloadDown(p *Peer, n uint64) {
+	p.payloadDown.Add(n)
+	t.payloadDown.Add(n)
+}
+
+func (t *Torrent) PayloadUp(p *Peer, n uint64) {
+	p.payloadUp.Add(n)
+	t.payloadUp.Add(n)
+}
+
+func (t *Torrent) tick(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	elapsed := now.Sub(t.lastTick)
+	if elapsed <= 0 {
+		return
+	}
+	t.lastTick = now
+	t.downRate.update(t.payloadDown.Load(), elapsed)
+	t.upRate.update(t.payloadUp.Load(), elapsed)
+	for _, p := range t.peers {
+		p.downRate.update(p.payloadDown.Load(), elapsed)
+		p.upRate.update(p.payloadUp.Load(), elapsed)
+	}
+}
+
+// Run updates the rates every TICK until stop is closed
+func (t *Torrent) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(TICK)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			t.tick(now)
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (t *Torrent) Snapshot() Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := Snapshot{
+		Total:              t.total,
+		Completed:          min(t.completed.Load(), t.total),
+		PiecesDone:         t.piecesDone.Load(),
+		PieceCount:         t.pieceCount,
+		Downloaded:         t.prevDown + t.payloadDown.Load(),
+		Uploaded:           t.prevUp + t.payloadUp.Load(),
+		ProtocolDownloaded: t.totalDown.Load() - min(t.payloadDown.Load(), t.totalDown.Load()),
+		ProtocolUploaded:   t.totalUp.Load() - min(t.payloadUp.Load(), t.totalUp.Load()),
+		Wasted:             t.wasted.Load(),
+		DownloadRate:       t.downRate.value,
+		UploadRate:         t.upRate.value,
+		ETA:                -1,
+	}
+	s.Left = s.Total - s.Completed
+	if s.Total > 0 {
+		s.Progress = float64(s.Completed) / float64(s.Total)
+	}
+	if s.Left == 0 {
+		s.ETA = 0
+	} else if s.DownloadRate >= 1 {
+		s.ETA = time.Duration(float64(s.Left) / s.DownloadRate * float64(time.Second))
+	}
+	if s.Downloaded > 0 {
+		s.Ratio = float64(s.Uploaded) / float64(s.Downloaded)
+	}
+
+	now := t.now()
+	for _, p := range t.peers {
+		s.Peers = append(s.Peers, PeerSnapshot{
+			Addr:         p.Addr,
+			Downloaded:   p.payloadDown.Load(),
+			Uploaded:     p.payloadUp.Load(),
+			DownloadRate: p.downRate.value,
+			UploadRate:   p.upRate.value,
+			Connected:    now.Sub(p.since),
+		})
+	}
+	slices.SortFunc(s.Peers, func(a, b PeerSnapshot) int { return strings.Compare(a.Addr, b.Addr) })
+	return s
+}
+
+// Conn counts every byte on a peer connection, payload included
+type Conn struct {
+	net.Conn
+	t    *Torrent
+	peer *Peer
+}
+
+func (t *Torrent) Wrap(conn net.Conn, p *Peer) *Conn {
+	return &Conn{Conn: conn, t: t, peer: p}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.peer.totalDown.Add(uint64(n))
+	c.t.totalDown.Add(uint64(n))
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.peer.totalUp.Add(uint64(n))
+	c.t.totalUp.Add(uint64(n))
+	return n, err
+}
+
+func FormatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
+
+// String is the one line status the CLI prints
+func (s Snapshot) String() string {
+	eta := "unknown"
+	if s.ETA >= 0 {
+		eta = s.ETA.Round(time.Second).String()
+	}
+	return fmt.Sprintf("%.1f%% (%d/%d pieces) | down %s/s up %s/s | ETA %s | peers %d | ratio %.2f | wasted %s | overhead %s/%s",
+		s.Progress*100, s.PiecesDone, s.PieceCount,
+		FormatBytes(s.DownloadRate), FormatBytes(s.UploadRate), eta, len(s.Peers), s.Ratio,
+		FormatBytes(float64(s.Wasted)), FormatBytes(float64(s.ProtocolDownloaded)), FormatBytes(float64(s.ProtocolUploaded)))
+}
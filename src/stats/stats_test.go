@@ -0,0 +1,151 @@
+package stats
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestTorrent is a torrent whose clock and rate ticks start at start, clock moves it
+func newTestTorrent(total uint64, pieceCount uint32) (*Torrent, *time.Time) {
+	now := start
+	t := NewTorrent(total, pieceCount)
+	t.lastTick = start
+	t.now = func() time.Time { return now }
+	return t, &now
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 0.01*math.Max(1, math.Abs(b))
+}
+
+func TestRate(t *testing.T) {
+	alpha := 1 - math.Exp(-1/RATE_WINDOW.Seconds())
+	tests := []struct {
+		name    string
+		perTick []uint64 // bytes received in each one second tick
+		want    float64
+	}{
+		{"one tick", []uint64{1000}, alpha * 1000},
+		{"steady", repeat(1000, 60), 1000},
+		{"stopped", append(repeat(1000, 60), repeat(0, 60)...), 0},
+		{"burst is smoothed", []uint64{0, 0, 10000, 0}, 10000 * alpha * (1 - alpha)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r rate
+			var total uint64
+			for _, n := range tt.perTick {
+				total += n
+				r.update(total, time.Second)
+			}
+			if !near(r.value, tt.want) {
+				t.Errorf("rate %.2f, want %.2f", r.value, tt.want)
+			}
+		})
+	}
+}
+
+func repeat(n uint64, count int) []uint64 {
+	s := make([]uint64, count)
+	for i := range s {
+		s[i] = n
+	}
+	return s
+}
+
+func TestSnapshotETAAndRatio(t *testing.T) {
+	tests := []struct {
+		name      string
+		completed uint64
+		perSecond uint64 // downloaded every second for a minute
+		uploaded  uint64
+		prevDown  uint64
+		wantETA   time.Duration
+		wantRatio float64
+	}{
+		{"unknown without a rate", 0, 0, 0, 0, -1, 0},
+		{"steady rate", 40000, 1000, 0, 0, 60 * time.Second, 0},
+		{"complete", 100000, 1000, 0, 0, 0, 0},
+		{"ratio over all sessions", 100000, 1000, 90000, 30000, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			torrent, now := newTestTorrent(100000, 10)
+			torrent.SetPrevious(tt.prevDown, 0)
+			p := torrent.AddPeer("10.0.0.1:6881")
+			for range 60 {
+				torrent.PayloadDown(p, tt.perSecond)
+				*now = now.Add(time.Second)
+				torrent.tick(*now)
+			}
+			torrent.PayloadUp(p, tt.uploaded)
+			torrent.SetCompleted(tt.completed, uint32(tt.completed/10000))
+
+			s := torrent.Snapshot()
+			if d := s.ETA - tt.wantETA; d < -time.Second || d > time.Second {
+				t.Errorf("ETA %s, want %s", s.ETA, tt.wantETA)
+			}
+			if !near(s.Ratio, tt.wantRatio) {
+				t.Errorf("ratio %.2f, want %.2f", s.Ratio, tt.wantRatio)
+			}
+			if s.Left != 100000-tt.completed || s.Progress != float64(tt.completed)/100000 {
+				t.Errorf("left %d, progress %.2f", s.Left, s.Progress)
+			}
+		})
+	}
+}
+
+// countedConn transfers n bytes on every read and write
+type countedConn struct {
+	net.Conn
+	n int
+}
+
+func (c countedConn) Read(b []byte) (int, error)  { return c.n, nil }
+func (c countedConn) Write(b []byte) (int, error) { return c.n, nil }
+
+func TestOverheadAndPeers(t *testing.T) {
+	torrent, now := newTestTorrent(1<<20, 64)
+	torrent.SetPrevious(500, 0)
+	b := torrent.AddPeer("10.0.0.2:6881")
+	*now = now.Add(10 * time.Second)
+	a := torrent.AddPeer("10.0.0.1:6881")
+
+	conn := torrent.Wrap(countedConn{n: 100}, a)
+	conn.Read(nil)
+	conn.Read(nil)
+	conn.Write(nil)
+	torrent.PayloadDown(a, 150) // of the 200 bytes read
+	torrent.PayloadUp(a, 30)    // of the 100 bytes written
+	*now = now.Add(5 * time.Second)
+
+	s := torrent.Snapshot()
+	if s.ProtocolDownloaded != 50 || s.ProtocolUploaded != 70 {
+		t.Errorf("overhead %d down, %d up", s.ProtocolDownloaded, s.ProtocolUploaded)
+	}
+	if s.Downloaded != 500+150 || s.Uploaded != 30 {
+		t.Errorf("payload %d down, %d up", s.Downloaded, s.Uploaded)
+	}
+	if down, up := torrent.Session(); down != 150 || up != 30 {
+		t.Errorf("session %d down, %d up", down, up)
+	}
+
+	if len(s.Peers) != 2 || s.Peers[0].Addr != "10.0.0.1:6881" {
+		t.Fatalf("peers %+v, want both sorted by address", s.Peers)
+	}
+	if s.Peers[0].Downloaded != 150 || s.Peers[0].Uploaded != 30 || s.Peers[0].Connected != 5*time.Second {
+		t.Errorf("peer a %+v", s.Peers[0])
+	}
+	if s.Peers[1].Downloaded != 0 || s.Peers[1].Connected != 15*time.Second {
+		t.Errorf("peer b %+v", s.Peers[1])
+	}
+
+	torrent.RemovePeer(b)
+	if peers := torrent.Snapshot().Peers; len(peers) != 1 {
+		t.Errorf("%d peers after removing one", len(peers))
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+	"torrent-client/src/tracker"
+)
+
+/*
+tracker => ./torrent-client tracker [-listen :6969] [-udp :6969] [-allow file] [-interval 30m]
+Runs an HTTP and a UDP tracker sharing the swarms, which are kept in memory.
+With -allow only the info hashes listed in the file (hex, one per line) are
+tracked.
+*/
+func trackerCommand(args []string) {
+	fs := flag.NewFlagSet("tracker", flag.ExitOnError)
+	listen := fs.String("listen", ":6969", "address to serve /announce and /scrape on")
+	udp := fs.String("udp", ":6969", "address to serve the UDP tracker protocol on, empty to disable it")
+	allow := fs.String("allow", "", "file with the info hashes to track, hex encoded, one per line; everything is tracked without it")
+	interval := fs.Duration("interval", tracker.DEFAULT_INTERVAL, "announce interval sent to clients, peers expire after twice the interval")
+	fs.Parse(args)
+
+	store := tracker.NewStore()
+	store.Interval = *interval
+	store.MinInterval = min(tracker.DEFAULT_MIN_INTERVAL, *interval)
+	store.PeerTTL = 2**interval + time.Minute
+	if *allow != "" {
+		hashes, err := tracker.LoadAllowlist(*allow)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Allowlist:", err)
+			os.Exit(1)
+		}
+		store.SetAllowlist(hashes)
+		fmt.Printf("Tracking %d torrents\n", len(hashes))
+	}
+	go store.Run(make(chan struct{}))
+
+	if *udp != "" {
+		go func() {
+			fmt.Printf("UDP tracker listening on %s\n", *udp)
+			if err := tracker.NewUDPServer(store).ListenAndServe(*udp); err != nil {
+				fmt.Fprintln(os.Stderr, "UDP tracker:", err)
+				os.Exit(1)
+			}
+		}()
+	}
+	fmt.Printf("HTTP tracker listening on %s\n", *listen)
+	if err := tracker.NewHTTPServer(store).ListenAndServe(*listen); err != nil {
+		fmt.Fprintln(os.Stderr, "Tracker:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,198 @@
+package tracker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+	"torrent-client/src/parser"
+	"torrent-client/src/peers"
+)
+
+// recorder is an HTTP tracker that records the event of every announce, without a handler it refuses them all
+type recorder struct {
+	handler http.Handler
+	url     string
+	got     chan string
+
+	mu     sync.Mutex
+	events []string
+}
+
+func newRecorder(t *testing.T, handler http.Handler) *recorder {
+	t.Helper()
+	r := &recorder{handler: handler, got: make(chan string, 100)}
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	r.url = srv.URL + "/announce"
+	return r
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	event := req.URL.Query().Get("event")
+	r.mu.Lock()
+	r.events = append(r.events, event)
+	r.mu.Unlock()
+	if r.handler == nil {
+		w.Write([]byte("d14:failure reason4:downe"))
+	} else {
+		r.handler.ServeHTTP(w, req)
+	}
+	r.got <- event
+}
+
+func (r *recorder) Events() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.events)
+}
+
+// wait waits for the next announce and checks its event
+func (r *recorder) wait(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case got := <-r.got:
+		if got != want {
+			t.Fatalf("%s: got event %q, want %q", r.url, got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s: no announce, want event %q", r.url, want)
+	}
+}
+
+// fastStore asks for a regular announce every second
+func fastStore(t *testing.T) *Store {
+	store := seededStore(t)
+	store.Interval = time.Second
+	store.MinInterval = 0
+	return store
+}
+
+// runAnnouncer runs an announcer for the trackers, the first one as the main announce, and returns its stop channel and the sources of the peers it found
+func runAnnouncer(trackers []*recorder, all bool) (*peers.Announcer, chan struct{}, func() []string) {
+	t := testTorrent(trackers[0].url)
+	for _, r := range trackers[1:] {
+		t.AnnounceList = append(t.AnnounceList, r.url)
+	}
+
+	var mu sync.Mutex
+	var sources []string
+	found := func(_ []parser.Peer, source string) {
+		mu.Lock()
+		if !slices.Contains(sources, source) {
+			sources = append(sources, source)
+		}
+		mu.Unlock()
+	}
+	a := peers.NewAnnouncer(t, func() (uint64, uint64, uint64) { return 0, 0, 100 }, found)
+	a.SetAnnounceAll(all)
+	stop := make(chan struct{})
+	go a.Run(stop)
+	return a, stop, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(sources)
+	}
+}
+
+func stopAnnouncer(t *testing.T, a *peers.Announcer, stop chan struct{}) {
+	t.Helper()
+	close(stop)
+	select {
+	case <-a.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the announcer did not stop")
+	}
+}
+
+func TestAnnouncerEvents(t *testing.T) {
+	r := newRecorder(t, NewHTTPServer(fastStore(t)))
+	a, stop, _ := runAnnouncer([]*recorder{r}, false)
+
+	r.wait(t, "started")
+	r.wait(t, "")
+	a.Completed()
+	r.wait(t, "completed")
+	a.Completed()
+	r.wait(t, "")
+	stopAnnouncer(t, a, stop)
+
+	events := r.Events()
+	if events[0] != "started" || events[len(events)-1] != "stopped" {
+		t.Fatalf("got events %q, want started first and stopped last", events)
+	}
+	completed := 0
+	for _, event := range events[1 : len(events)-1] {
+		switch event {
+		case "completed":
+			completed++
+		case "":
+		default:
+			t.Errorf("got event %q between started and stopped", event)
+		}
+	}
+	if completed != 1 {
+		t.Errorf("completed sent %d times in %q, want once", completed, events)
+	}
+}
+
+func TestAnnouncerFallback(t *testing.T) {
+	down := newRecorder(t, nil)
+	up := newRecorder(t, NewHTTPServer(fastStore(t)))
+	a, stop, sources := runAnnouncer([]*recorder{down, up}, false)
+
+	down.wait(t, "started")
+	up.wait(t, "started")
+	// the tracker that answered is tried first from now on
+	up.wait(t, "")
+	up.wait(t, "")
+	stopAnnouncer(t, a, stop)
+
+	if events := down.Events(); !slices.Equal(events, []string{"started"}) {
+		t.Errorf("the failed tracker got %q, want only the first started", events)
+	}
+	if events := up.Events(); events[len(events)-1] != "stopped" {
+		t.Errorf("the working tracker got %q, want stopped last", events)
+	}
+	if got := sources(); !slices.Equal(got, []string{up.url}) {
+		t.Errorf("peers from %q, want only from %s", got, up.url)
+	}
+}
+
+func TestAnnouncerAll(t *testing.T) {
+	store := fastStore(t)
+	down := newRecorder(t, nil)
+	first := newRecorder(t, NewHTTPServer(store))
+	second := newRecorder(t, NewHTTPServer(store))
+	a, stop, sources := runAnnouncer([]*recorder{down, first, second}, true)
+
+	down.wait(t, "started")
+	first.wait(t, "started")
+	second.wait(t, "started")
+	a.Completed()
+	first.wait(t, "completed")
+	second.wait(t, "completed")
+	stopAnnouncer(t, a, stop)
+
+	// a tracker that never answered is not told about the completion or the stop
+	if events := down.Events(); !slices.Equal(events, []string{"started"}) {
+		t.Errorf("the failed tracker got %q, want only started", events)
+	}
+	for _, r := range []*recorder{first, second} {
+		events := r.Events()
+		completed := 0
+		for _, event := range events {
+			if event == "completed" {
+				completed++
+			}
+		}
+		if events[len(events)-1] != "stopped" || completed != 1 {
+			t.Errorf("%s got %q, want one completed and stopped last", r.url, events)
+		}
+	}
+	if got := sources(); len(got) != 2 || !slices.Contains(got, first.url) || !slices.Contains(got, second.url) {
+		t.Errorf("peers from %q, want from both working trackers", got)
+	}
+}
@@ -0,0 +1,217 @@
+package tracker
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"torrent-client/src/encoder"
+	"torrent-client/src/peers"
+)
+
+/*
+HTTPServer serves the HTTP tracker protocol (BEP 3) on /announce and /scrape.
+
+Peers are returned compact (BEP 23) unless the client sends compact=0, IPv4
+peers in "peers" and IPv6 peers in "peers6" (BEP 7). A peer is registered with
+the address its request came from; the ip, ipv4 and ipv6 parameters add the
+addresses it has in the other family. Refused or malformed requests get a
+bencoded failure reason, as the protocol has no other way to report them.
+*/
+
+type HTTPServer struct {
+	store *Store
+	mux   *http.ServeMux
+}
+
+func NewHTTPServer(store *Store) *HTTPServer {
+	s := &HTTPServer{store: store, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/announce", s.announce)
+	s.mux.HandleFunc("/scrape", s.scrape)
+	return s
+}
+
+func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the tracker on addr until it fails
+func (s *HTTPServer) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s)
+}
+
+func writeBencoded(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(body))
+}
+
+func writeFailure(w http.ResponseWriter, reason string) {
+	writeBencoded(w, encoder.BencodeDict(map[string]string{"failure reason": encoder.BencodeString(reason)}))
+}
+
+// hash20 reads a 20 byte query value (info_hash, peer_id)
+func hash20(value string, name string) ([20]byte, error) {
+	if len(value) != 20 {
+		return [20]byte{}, fmt.Errorf("invalid %s", name)
+	}
+	return [20]byte([]byte(value)), nil
+}
+
+// remoteIP is the address the request came from, IPv4 addresses in their 4 byte form
+func remoteIP(r *http.Request) (net.IP, error) {
+	addr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	return net.IP(addr.Addr().Unmap().AsSlice()), nil
+}
+
+// setAddress puts ip into the field of its address family
+func setAddress(a *Announce, ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		a.IPv4 = ip4
+	} else if ip != nil {
+		a.IPv6 = ip
+	}
+}
+
+func parseAnnounce(r *http.Request) (Announce, error) {
+	q := r.URL.Query()
+	var a Announce
+	var err error
+	if a.InfoHash, err = hash20(q.Get("info_hash"), "info_hash"); err != nil {
+		return a, err
+	}
+	if a.PeerId, err = hash20(q.Get("peer_id"), "peer_id"); err != nil {
+		return a, err
+	}
+	port, err := strconv.ParseUint(q.Get("port"), 10, 16)
+	if err != nil {
+		return a, fmt.Errorf("invalid port")
+	}
+	a.Port = uint16(port)
+
+	for name, dst := range map[string]*uint64{"uploaded": &a.Uploaded, "downloaded": &a.Downloaded, "left": &a.Left} {
+		if value := q.Get(name); value != "" {
+			if *dst, err = strconv.ParseUint(value, 10, 64); err != nil {
+				return a, fmt.Errorf("invalid %s", name)
+			}
+		}
+	}
+	if a.Event, err = peers.ParseEvent(q.Get("event")); err != nil {
+		return a, err
+	}
+	a.NumWant = -1
+	if value := q.Get("numwant"); value != "" {
+		if a.NumWant, err = strconv.Atoi(value); err != nil || a.NumWant < 0 {
+			return a, fmt.Errorf("invalid numwant")
+		}
+	}
+
+	ip, err := remoteIP(r)
+	if err != nil {
+		return a, fmt.Errorf("unknown remote address")
+	}
+	// addresses of the other family only, a client cannot register someone else's address in its own
+	for _, name := range []string{"ip", "ipv4", "ipv6"} {
+		extra := net.ParseIP(q.Get(name))
+		if extra != nil && (extra.To4() == nil) != (ip.To4() == nil) {
+			setAddress(&a, extra)
+		}
+	}
+	setAddress(&a, ip)
+	return a, nil
+}
+
+func compactPeers(peers []Peer) (v4 []byte, v6 []byte) {
+	for _, p := range peers {
+		if p.IPv4 != nil {
+			v4 = append(v4, p.IPv4.To4()...)
+			v4 = append(v4, byte(p.Port>>8), byte(p.Port))
+		}
+		if p.IPv6 != nil {
+			v6 = append(v6, p.IPv6.To16()...)
+			v6 = append(v6, byte(p.Port>>8), byte(p.Port))
+		}
+	}
+	return v4, v6
+}
+
+func peerList(peers []Peer, noPeerId bool) string {
+	var out strings.Builder
+	out.WriteString("l")
+	for _, p := range peers {
+		for _, ip := range []net.IP{p.IPv4, p.IPv6} {
+			if ip == nil {
+				continue
+			}
+			peer := map[string]string{"ip": encoder.BencodeString(ip.String()), "port": encoder.BencodeInt(uint64(p.Port))}
+			if !noPeerId {
+				peer["peer id"] = encoder.BencodeBytes(p.PeerId[:])
+			}
+			out.WriteString(encoder.BencodeDict(peer))
+		}
+	}
+	out.WriteString("e")
+	return out.String()
+}
+
+func (s *HTTPServer) announce(w http.ResponseWriter, r *http.Request) {
+	a, err := parseAnnounce(r)
+	if err != nil {
+		writeFailure(w, err.Error())
+		return
+	}
+	res, err := s.store.Announce(a)
+	if err != nil {
+		writeFailure(w, err.Error())
+		return
+	}
+
+	q := r.URL.Query()
+	body := map[string]string{
+		"interval":     encoder.BencodeInt(uint64(res.Interval.Seconds())),
+		"min interval": encoder.BencodeInt(uint64(res.MinInterval.Seconds())),
+		"complete":     encoder.BencodeInt(uint64(res.Complete)),
+		"incomplete":   encoder.BencodeInt(uint64(res.Incomplete)),
+	}
+	if q.Get("compact") == "0" {
+		body["peers"] = peerList(res.Peers, q.Get("no_peer_id") == "1")
+	} else {
+		v4, v6 := compactPeers(res.Peers)
+		body["peers"] = encoder.BencodeBytes(v4)
+		if len(v6) > 0 {
+			body["peers6"] = encoder.BencodeBytes(v6)
+		}
+	}
+	writeBencoded(w, encoder.BencodeDict(body))
+}
+
+func (s *HTTPServer) scrape(w http.ResponseWriter, r *http.Request) {
+	var hashes [][20]byte
+	for _, value := range r.URL.Query()["info_hash"] {
+		hash, err := hash20(value, "info_hash")
+		if err != nil {
+			writeFailure(w, err.Error())
+			return
+		}
+		hashes = append(hashes, hash)
+	}
+	stats, err := s.store.Scrape(hashes)
+	if err != nil {
+		writeFailure(w, err.Error())
+		return
+	}
+
+	files := make(map[string]string, len(stats))
+	for hash, st := range stats {
+		files[string(hash[:])] = encoder.BencodeDict(map[string]string{
+			"complete":   encoder.BencodeInt(uint64(st.Complete)),
+			"downloaded": encoder.BencodeInt(uint64(st.Downloaded)),
+			"incomplete": encoder.BencodeInt(uint64(st.Incomplete)),
+		})
+	}
+	writeBencoded(w, encoder.BencodeDict(map[string]string{"files": encoder.BencodeDict(files)}))
+}
@@ -0,0 +1,192 @@
+package tracker
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"torrent-client/src/parser"
+	"torrent-client/src/peers"
+)
+
+var testHash = [20]byte{1, 2, 3}
+
+// seededStore has a swarm with one seeder at 10.0.0.1:6000
+func seededStore(t *testing.T) *Store {
+	t.Helper()
+	store := NewStore()
+	_, err := store.Announce(Announce{
+		InfoHash: testHash,
+		PeerId:   [20]byte{'s', 'e', 'e', 'd'},
+		IPv4:     net.IPv4(10, 0, 0, 1).To4(),
+		Port:     6000,
+		Event:    peers.EVENT_STARTED,
+		NumWant:  -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func testTorrent(announce string) *parser.Torrent {
+	return &parser.Torrent{Announce: announce, InfoHash: testHash[:], TotalLength: 100}
+}
+
+func hasPeer(list []parser.Peer, ip string, port uint16) bool {
+	for _, p := range list {
+		if p.Ip.String() == ip && p.Port == port {
+			return true
+		}
+	}
+	return false
+}
+
+// udpServer serves store on a local socket until the test ends and returns its announce url
+func udpServer(t *testing.T, store *Store) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go NewUDPServer(store).Serve(conn)
+	return "udp://" + conn.LocalAddr().String() + "/announce"
+}
+
+func TestHTTPRoundTrip(t *testing.T) {
+	store := seededStore(t)
+	srv := httptest.NewServer(NewHTTPServer(store))
+	defer srv.Close()
+	announce := srv.URL + "/announce"
+
+	res, err := peers.RequestTracker(testTorrent(announce), announce, peers.AnnounceParams{Event: peers.EVENT_STARTED, Left: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Interval != uint32(DEFAULT_INTERVAL.Seconds()) || res.MinInterval != uint32(DEFAULT_MIN_INTERVAL.Seconds()) {
+		t.Errorf("interval %d, min interval %d", res.Interval, res.MinInterval)
+	}
+	if res.Complete != 1 || res.Incomplete != 1 {
+		t.Errorf("got %d seeders and %d leechers, want 1 and 1", res.Complete, res.Incomplete)
+	}
+	if !hasPeer(res.Peers, "10.0.0.1", 6000) {
+		t.Errorf("the seeder is missing from %v", res.Peers)
+	}
+
+	scrape, err := peers.Scrape(announce, [][20]byte{testHash}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := scrape[testHash]; st.Complete != 1 || st.Incomplete != 1 {
+		t.Errorf("scrape %+v, want 1 seeder and 1 leecher", st)
+	}
+
+	if _, err := peers.RequestTracker(testTorrent(announce), announce, peers.AnnounceParams{Event: peers.EVENT_STOPPED, Left: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := store.Scrape([][20]byte{testHash}); st[testHash].Incomplete != 0 {
+		t.Errorf("the stopped peer is still in the swarm: %+v", st[testHash])
+	}
+}
+
+func TestHTTPRefused(t *testing.T) {
+	store := seededStore(t)
+	store.SetAllowlist([][20]byte{{9}})
+	srv := httptest.NewServer(NewHTTPServer(store))
+	defer srv.Close()
+	announce := srv.URL + "/announce"
+
+	_, err := peers.RequestTracker(testTorrent(announce), announce, peers.AnnounceParams{Event: peers.EVENT_STARTED, Left: 100})
+	var failure *peers.FailureError
+	if !errors.As(err, &failure) {
+		t.Fatalf("got %v, want a FailureError", err)
+	}
+}
+
+func TestUDPRoundTrip(t *testing.T) {
+	store := seededStore(t)
+	announce := udpServer(t, store)
+
+	res, err := peers.RequestTracker(testTorrent(announce), announce, peers.AnnounceParams{Event: peers.EVENT_STARTED, Left: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Interval != uint32(DEFAULT_INTERVAL.Seconds()) {
+		t.Errorf("interval %d", res.Interval)
+	}
+	if res.Complete != 1 || res.Incomplete != 1 {
+		t.Errorf("got %d seeders and %d leechers, want 1 and 1", res.Complete, res.Incomplete)
+	}
+	if !hasPeer(res.Peers, "10.0.0.1", 6000) {
+		t.Errorf("the seeder is missing from %v", res.Peers)
+	}
+
+	client := peers.NewUDPClient(1)
+	defer client.Close()
+	scrape, err := peers.Scrape(announce, [][20]byte{testHash, {7}}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := scrape[testHash]; st.Complete != 1 || st.Incomplete != 1 {
+		t.Errorf("scrape %+v, want 1 seeder and 1 leecher", st)
+	}
+	if st, ok := scrape[[20]byte{7}]; !ok || st.Complete != 0 {
+		t.Errorf("unknown torrent scraped as %+v (present %v)", st, ok)
+	}
+}
+
+func TestUDPRefused(t *testing.T) {
+	store := seededStore(t)
+	store.SetAllowlist([][20]byte{{9}})
+	announce := udpServer(t, store)
+
+	_, err := peers.RequestTracker(testTorrent(announce), announce, peers.AnnounceParams{Event: peers.EVENT_STARTED, Left: 100})
+	var failure *peers.FailureError
+	if !errors.As(err, &failure) {
+		t.Fatalf("got %v, want a FailureError", err)
+	}
+}
+
+// an announce to a tracker that never answers ends as soon as it is canceled
+func TestUDPCancel(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	announce := "udp://" + conn.LocalAddr().String() + "/announce"
+
+	cancel := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(cancel) })
+	start := time.Now()
+	_, err = peers.RequestTracker(testTorrent(announce), announce, peers.AnnounceParams{Event: peers.EVENT_STARTED, Cancel: cancel})
+	if !errors.Is(err, peers.ErrCanceled) {
+		t.Errorf("got %v, want ErrCanceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > peers.UDP_TIMEOUT {
+		t.Errorf("cancel took %s", elapsed)
+	}
+}
+
+// same for an HTTP tracker that accepts the request and never answers it
+func TestHTTPCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	announce := srv.URL + "/announce"
+
+	cancel := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(cancel) })
+	start := time.Now()
+	_, err := peers.RequestTracker(testTorrent(announce), announce, peers.AnnounceParams{Event: peers.EVENT_STARTED, Cancel: cancel})
+	if !errors.Is(err, peers.ErrCanceled) {
+		t.Errorf("got %v, want ErrCanceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancel took %s", elapsed)
+	}
+}
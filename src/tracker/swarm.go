@@ -0,0 +1,267 @@
+package tracker
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"torrent-client/src/peers"
+)
+
+/*
+Store keeps the swarms of a tracker in memory, shared by its HTTP and UDP
+front ends. A peer is identified by its peer id and may be reachable over
+IPv4, IPv6 or both (BEP 7). Peers that stop announcing are dropped PeerTTL
+after their last announce.
+
+With an allowlist only the listed info hashes are tracked, announces and
+scrapes for anything else are refused.
+*/
+
+const DEFAULT_INTERVAL = 30 * time.Minute
+const DEFAULT_MIN_INTERVAL = time.Minute
+const DEFAULT_NUMWANT = 50
+const MAX_NUMWANT = 200
+const EXPIRY_CHECK = time.Minute // how often Run drops expired peers
+
+type Announce struct {
+	InfoHash   [20]byte
+	PeerId     [20]byte
+	IPv4       net.IP // nil when unknown
+	IPv6       net.IP
+	Port       uint16
+	Uploaded   uint64
+	Downloaded uint64
+	Left       uint64
+	Event      peers.Event
+	NumWant    int // < 0 for the default
+}
+
+type Peer struct {
+	PeerId   [20]byte
+	IPv4     net.IP
+	IPv6     net.IP
+	Port     uint16
+	left     uint64
+	lastSeen time.Time
+}
+
+type ScrapeStats struct {
+	Complete   uint32 // seeders
+	Downloaded uint32 // completed events ever received
+	Incomplete uint32 // leechers
+}
+
+type AnnounceResult struct {
+	Interval    time.Duration
+	MinInterval time.Duration
+	ScrapeStats
+	Peers []Peer
+}
+
+type swarm struct {
+	peers      map[[20]byte]*Peer
+	downloaded uint32
+}
+
+type Store struct {
+	Interval    time.Duration
+	MinInterval time.Duration
+	PeerTTL     time.Duration
+
+	mu     sync.Mutex
+	swarms map[[20]byte]*swarm
+	allow  map[[20]byte]bool // nil allows everything
+}
+
+func NewStore() *Store {
+	return &Store{
+		Interval:    DEFAULT_INTERVAL,
+		MinInterval: DEFAULT_MIN_INTERVAL,
+		PeerTTL:     2*DEFAULT_INTERVAL + time.Minute,
+		swarms:      make(map[[20]byte]*swarm),
+	}
+}
+
+// SetAllowlist restricts the tracker to the info hashes, nil lifts the restriction
+func (s *Store) SetAllowlist(hashes [][20]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hashes == nil {
+		s.allow = nil
+		return
+	}
+	s.allow = make(map[[20]byte]bool, len(hashes))
+	for _, hash := range hashes {
+		s.allow[hash] = true
+	}
+	for hash := range s.swarms {
+		if !s.allow[hash] {
+			delete(s.swarms, hash)
+		}
+	}
+}
+
+// LoadAllowlist reads hex encoded info hashes, one per line, blank lines and lines starting with # are skipped
+func LoadAllowlist(path string) ([][20]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hashes := [][20]byte{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		b, err := hex.DecodeString(text)
+		if err != nil || len(b) != 20 {
+			return nil, fmt.Errorf("%s:%d: invalid info hash %q", path, line, text)
+		}
+		hashes = append(hashes, [20]byte(b))
+	}
+	return hashes, scanner.Err()
+}
+
+func (s *Store) allowed(hash [20]byte) bool {
+	return s.allow == nil || s.allow[hash]
+}
+
+func (sw *swarm) stats() ScrapeStats {
+	stats := ScrapeStats{Downloaded: sw.downloaded}
+	for _, p := range sw.peers {
+		if p.left == 0 {
+			stats.Complete++
+		} else {
+			stats.Incomplete++
+		}
+	}
+	return stats
+}
+
+// Announce records an announce and returns up to NumWant other peers of the swarm
+func (s *Store) Announce(a Announce) (*AnnounceResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.allowed(a.InfoHash) {
+		return nil, fmt.Errorf("torrent not allowed on this tracker")
+	}
+	if a.Port == 0 {
+		return nil, fmt.Errorf("invalid port")
+	}
+
+	sw, ok := s.swarms[a.InfoHash]
+	if !ok {
+		sw = &swarm{peers: make(map[[20]byte]*Peer)}
+		s.swarms[a.InfoHash] = sw
+	}
+
+	now := time.Now()
+	if a.Event == peers.EVENT_STOPPED {
+		delete(sw.peers, a.PeerId)
+	} else {
+		p, ok := sw.peers[a.PeerId]
+		if !ok {
+			p = &Peer{PeerId: a.PeerId}
+			sw.peers[a.PeerId] = p
+		}
+		if a.Event == peers.EVENT_COMPLETED && (!ok || p.left != 0) {
+			sw.downloaded++
+		}
+		if a.IPv4 != nil {
+			p.IPv4 = a.IPv4
+		}
+		if a.IPv6 != nil {
+			p.IPv6 = a.IPv6
+		}
+		p.Port = a.Port
+		p.left = a.Left
+		p.lastSeen = now
+	}
+
+	res := &AnnounceResult{Interval: s.Interval, MinInterval: s.MinInterval, ScrapeStats: sw.stats()}
+	if a.Event == peers.EVENT_STOPPED {
+		return res, nil
+	}
+
+	want := a.NumWant
+	if want < 0 {
+		want = DEFAULT_NUMWANT
+	}
+	want = min(want, MAX_NUMWANT)
+	// seeders have no use for other seeders
+	candidates := make([]*Peer, 0, len(sw.peers))
+	for id, p := range sw.peers {
+		if id == a.PeerId || (a.Left == 0 && p.left == 0) {
+			continue
+		}
+		candidates = append(candidates, p)
+	}
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	for _, p := range candidates[:min(want, len(candidates))] {
+		res.Peers = append(res.Peers, *p)
+	}
+	return res, nil
+}
+
+// Scrape returns the stats of the info hashes, of every swarm when none are given
+func (s *Store) Scrape(hashes [][20]byte) (map[[20]byte]ScrapeStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make(map[[20]byte]ScrapeStats)
+	if len(hashes) == 0 {
+		for hash, sw := range s.swarms {
+			stats[hash] = sw.stats()
+		}
+		return stats, nil
+	}
+	for _, hash := range hashes {
+		if !s.allowed(hash) {
+			return nil, fmt.Errorf("torrent not allowed on this tracker")
+		}
+		if sw, ok := s.swarms[hash]; ok {
+			stats[hash] = sw.stats()
+		} else {
+			stats[hash] = ScrapeStats{}
+		}
+	}
+	return stats, nil
+}
+
+// Expire drops the peers that did not announce within PeerTTL and swarms left without peers
+func (s *Store) Expire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, sw := range s.swarms {
+		for id, p := range sw.peers {
+			if now.Sub(p.lastSeen) > s.PeerTTL {
+				delete(sw.peers, id)
+			}
+		}
+		if len(sw.peers) == 0 && sw.downloaded == 0 {
+			delete(s.swarms, hash)
+		}
+	}
+}
+
+// Run drops expired peers every EXPIRY_CHECK until stop is closed
+func (s *Store) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(EXPIRY_CHECK)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.Expire(now)
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package tracker
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+	"torrent-client/src/peers"
+)
+
+/*
+UDPServer serves the UDP tracker protocol (BEP 15) from the same Store as the
+HTTP tracker:
+
+- connect hands out a connection id derived from the client's IP and a
+  secret, so nothing is stored per connection. The secret is replaced every
+  SECRET_ROTATION and the previous one stays valid, an id works for at least
+  SECRET_ROTATION and at most twice that
+- announce and scrape are only answered for a valid connection id, a request
+  for an expired id is dropped so the client connects again
+- anything that can be answered but not served gets an error (action 3)
+
+The IP address field of announces is ignored, peers are registered with the
+address the packet came from and get peers of that address family. Every IP
+may send RateBurst packets at once and RatePerSecond after that, anything
+above is dropped silently.
+*/
+
+const SECRET_ROTATION = 2 * time.Minute
+const DEFAULT_RATE_PER_SECOND = 5
+const DEFAULT_RATE_BURST = 20
+const MAX_SCRAPE_HASHES = 74 // what fits in a 1500 byte packet
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type UDPServer struct {
+	RatePerSecond float64
+	RateBurst     float64
+
+	store *Store
+
+	mu       sync.Mutex
+	secret   [32]byte
+	previous [32]byte
+	rotated  time.Time
+	buckets  map[netip.Addr]*bucket
+}
+
+func NewUDPServer(store *Store) *UDPServer {
+	return &UDPServer{
+		RatePerSecond: DEFAULT_RATE_PER_SECOND,
+		RateBurst:     DEFAULT_RATE_BURST,
+		store:         store,
+		buckets:       make(map[netip.Addr]*bucket),
+	}
+}
+
+// ListenAndServe serves the tracker on addr until the socket fails
+func (s *UDPServer) ListenAndServe(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return s.Serve(conn)
+}
+
+// Serve answers the requests arriving on conn until reading from it fails
+func (s *UDPServer) Serve(conn *net.UDPConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return err
+		}
+		from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+		if n < 16 || !s.allow(from.Addr(), time.Now()) {
+			continue
+		}
+		if res := s.handle(buf[:n], from); res != nil {
+			conn.WriteToUDPAddrPort(res, from)
+		}
+	}
+}
+
+// rotate replaces the secrets that are too old, callers hold mu
+func (s *UDPServer) rotate(now time.Time) {
+	elapsed := now.Sub(s.rotated)
+	if elapsed < SECRET_ROTATION {
+		return
+	}
+	if elapsed < 2*SECRET_ROTATION {
+		s.previous = s.secret
+	} else {
+		rand.Read(s.previous[:])
+	}
+	rand.Read(s.secret[:])
+	s.rotated = now
+
+	// a full bucket is the same as no bucket
+	for ip, b := range s.buckets {
+		if now.Sub(b.last).Seconds()*s.RatePerSecond >= s.RateBurst {
+			delete(s.buckets, ip)
+		}
+	}
+}
+
+func connectionId(secret [32]byte, ip netip.Addr) uint64 {
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write(ip.AsSlice())
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+func (s *UDPServer) newConnectionId(ip netip.Addr, now time.Time) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotate(now)
+	return connectionId(s.secret, ip)
+}
+
+func (s *UDPServer) validConnectionId(id uint64, ip netip.Addr, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotate(now)
+	return id == connectionId(s.secret, ip) || id == connectionId(s.previous, ip)
+}
+
+// allow takes a token from the bucket of ip, false when it is empty
+func (s *UDPServer) allow(ip netip.Addr, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: s.RateBurst, last: now}
+		s.buckets[ip] = b
+	}
+	b.tokens = min(s.RateBurst, b.tokens+now.Sub(b.last).Seconds()*s.RatePerSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func header(action uint32, txID uint32, size int) []byte {
+	buf := make([]byte, 8, 8+size)
+	binary.BigEndian.PutUint32(buf[0:4], action)
+	binary.BigEndian.PutUint32(buf[4:8], txID)
+	return buf
+}
+
+func errorResponse(txID uint32, message string) []byte {
+	return append(header(peers.ACTION_ERROR, txID, len(message)), message...)
+}
+
+// handle returns the response to a request, nil when it is not answered
+func (s *UDPServer) handle(req []byte, from netip.AddrPort) []byte {
+	action := binary.BigEndian.Uint32(req[8:12])
+	txID := binary.BigEndian.Uint32(req[12:16])
+	connID := binary.BigEndian.Uint64(req[0:8])
+
+	if action == peers.ACTION_CONNECT {
+		if connID != peers.PROTOCOL_ID {
+			return nil
+		}
+		res := header(peers.ACTION_CONNECT, txID, 8)
+		return binary.BigEndian.AppendUint64(res, s.newConnectionId(from.Addr(), time.Now()))
+	}
+	if !s.validConnectionId(connID, from.Addr(), time.Now()) {
+		return nil
+	}
+
+	switch action {
+	case peers.ACTION_ANNOUNCE:
+		return s.announce(req, txID, from)
+	case peers.ACTION_SCRAPE:
+		return s.scrape(req, txID)
+	}
+	return errorResponse(txID, "unknown action")
+}
+
+func (s *UDPServer) announce(req []byte, txID uint32, from netip.AddrPort) []byte {
+	if len(req) < 98 {
+		return errorResponse(txID, "announce request too short")
+	}
+	a := Announce{
+		InfoHash:   [20]byte(req[16:36]),
+		PeerId:     [20]byte(req[36:56]),
+		Downloaded: binary.BigEndian.Uint64(req[56:64]),
+		Left:       binary.BigEndian.Uint64(req[64:72]),
+		Uploaded:   binary.BigEndian.Uint64(req[72:80]),
+		Event:      peers.Event(binary.BigEndian.Uint32(req[80:84])),
+		NumWant:    int(int32(binary.BigEndian.Uint32(req[92:96]))),
+		Port:       binary.BigEndian.Uint16(req[96:98]),
+	}
+	if a.Event > peers.EVENT_STOPPED {
+		return errorResponse(txID, "unknown event")
+	}
+	ip := net.IP(from.Addr().AsSlice())
+	v4 := from.Addr().Is4()
+	if v4 {
+		a.IPv4 = ip
+	} else {
+		a.IPv6 = ip
+	}
+
+	result, err := s.store.Announce(a)
+	if err != nil {
+		return errorResponse(txID, err.Error())
+	}
+	res := header(peers.ACTION_ANNOUNCE, txID, 12+18*len(result.Peers))
+	res = binary.BigEndian.AppendUint32(res, uint32(result.Interval.Seconds()))
+	res = binary.BigEndian.AppendUint32(res, result.Incomplete)
+	res = binary.BigEndian.AppendUint32(res, result.Complete)
+	for _, p := range result.Peers {
+		peerIP := p.IPv6
+		if v4 {
+			peerIP = p.IPv4
+		}
+		if peerIP == nil {
+			continue
+		}
+		if v4 {
+			res = append(res, peerIP.To4()...)
+		} else {
+			res = append(res, peerIP.To16()...)
+		}
+		res = binary.BigEndian.AppendUint16(res, p.Port)
+	}
+	return res
+}
+
+func (s *UDPServer) scrape(req []byte, txID uint32) []byte {
+	var hashes [][20]byte
+	for off := 16; off+20 <= len(req) && len(hashes) < MAX_SCRAPE_HASHES; off += 20 {
+		hashes = append(hashes, [20]byte(req[off:off+20]))
+	}
+	if len(hashes) == 0 {
+		return errorResponse(txID, "no info hash to scrape")
+	}
+	stats, err := s.store.Scrape(hashes)
+	if err != nil {
+		return errorResponse(txID, err.Error())
+	}
+	res := header(peers.ACTION_SCRAPE, txID, 12*len(hashes))
+	for _, hash := range hashes {
+		st := stats[hash]
+		res = binary.BigEndian.AppendUint32(res, st.Complete)
+		res = binary.BigEndian.AppendUint32(res, st.Downloaded)
+		res = binary.BigEndian.AppendUint32(res, st.Incomplete)
+	}
+	return res
+}
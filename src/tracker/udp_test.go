@@ -0,0 +1,102 @@
+package tracker
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+var (
+	clientIP = netip.MustParseAddr("192.0.2.1")
+	otherIP  = netip.MustParseAddr("192.0.2.2")
+)
+
+func TestConnectionIdRotation(t *testing.T) {
+	s := NewUDPServer(NewStore())
+	start := time.Now()
+	id := s.newConnectionId(clientIP, start)
+
+	tests := []struct {
+		name  string
+		after time.Duration // since the id was handed out
+		ip    netip.Addr
+		valid bool
+	}{
+		{"right away", 0, clientIP, true},
+		{"from another address", 0, otherIP, false},
+		{"just before the rotation", SECRET_ROTATION - time.Second, clientIP, true},
+		{"after one rotation", SECRET_ROTATION + time.Second, clientIP, true},
+		{"after two rotations", 2*SECRET_ROTATION + 2*time.Second, clientIP, false},
+	}
+	for _, tt := range tests {
+		if got := s.validConnectionId(id, tt.ip, start.Add(tt.after)); got != tt.valid {
+			t.Errorf("%s: valid %v, want %v", tt.name, got, tt.valid)
+		}
+	}
+}
+
+func TestConnectionIdExpiresAfterIdleServer(t *testing.T) {
+	s := NewUDPServer(NewStore())
+	start := time.Now()
+	id := s.newConnectionId(clientIP, start)
+
+	// nothing arrived for a long time, both secrets are replaced at once
+	if s.validConnectionId(id, clientIP, start.Add(10*SECRET_ROTATION)) {
+		t.Error("an id survived a long idle period")
+	}
+	fresh := s.newConnectionId(clientIP, start.Add(10*SECRET_ROTATION))
+	if fresh == id || !s.validConnectionId(fresh, clientIP, start.Add(10*SECRET_ROTATION)) {
+		t.Error("the new secret does not hand out working ids")
+	}
+}
+
+func TestAllow(t *testing.T) {
+	s := NewUDPServer(NewStore())
+	s.RatePerSecond = 2
+	s.RateBurst = 4
+	start := time.Now()
+
+	steps := []struct {
+		name    string
+		at      time.Duration
+		ip      netip.Addr
+		packets int
+		allowed int
+	}{
+		{"burst", 0, clientIP, 6, 4},
+		{"another address has its own bucket", 0, otherIP, 1, 1},
+		{"half a second refills one token", 500 * time.Millisecond, clientIP, 2, 1},
+		{"the refill is capped at the burst", 10 * time.Second, clientIP, 6, 4},
+	}
+	for _, step := range steps {
+		allowed := 0
+		for range step.packets {
+			if s.allow(step.ip, start.Add(step.at)) {
+				allowed++
+			}
+		}
+		if allowed != step.allowed {
+			t.Errorf("%s: %d of %d packets allowed, want %d", step.name, allowed, step.packets, step.allowed)
+		}
+	}
+}
+
+func TestRotateDropsFullBuckets(t *testing.T) {
+	s := NewUDPServer(NewStore())
+	start := time.Now()
+	s.rotate(start)
+	s.allow(clientIP, start)
+	s.allow(otherIP, start.Add(SECRET_ROTATION))
+	for range int(s.RateBurst) {
+		s.allow(otherIP, start.Add(SECRET_ROTATION))
+	}
+
+	s.mu.Lock()
+	s.rotate(start.Add(SECRET_ROTATION))
+	_, idle := s.buckets[clientIP]
+	_, busy := s.buckets[otherIP]
+	s.mu.Unlock()
+	if idle || !busy {
+		t.Errorf("after rotating: idle bucket kept %v, busy bucket kept %v", idle, busy)
+	}
+}
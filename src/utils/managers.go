@@ -91,6 +91,19 @@ func (s *Downloaded) Remove(dIndex int, bIndex int) {
 	s.mu.Unlock()
 }
 
+// Load replaces the bitfield, e.g. with one restored from resume data
+func (s *Downloaded) Load(bitfield []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copy(s.content, bitfield)
+	s.pieceCount = 0
+	for _, b := range s.content {
+		for ; b != 0; b &= b - 1 {
+			s.pieceCount++
+		}
+	}
+}
+
 func (s *Downloaded) GetContent() []byte {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -107,7 +120,7 @@ func (s *Downloaded) GetPieceCount() uint32 {
 
 /*--------------------- PEER FUNCTIONS -----------------------*/
 func NewPeerList(peerList []parser.Peer) *AvailablePeers {
-	var av AvailablePeers
+	av := AvailablePeers{peers: make(map[string]parser.Peer)}
 	for _, peer := range peerList {
 		av.peers[peer.Ip.String()] = peer
 	}
@@ -125,3 +138,13 @@ func (s *AvailablePeers) Remove(peer parser.Peer) {
 	delete(s.peers, peer.Ip.String())
 	s.mu.Unlock()
 }
+
+func (s *AvailablePeers) List() []parser.Peer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]parser.Peer, 0, len(s.peers))
+	for _, peer := range s.peers {
+		list = append(list, peer)
+	}
+	return list
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"torrent-client/src/download"
+	"torrent-client/src/utils"
+)
+
+/*
+verify => ./torrent-client verify [file path] [data path]
+Rechecks the data of a torrent on disk against its piece hashes without
+connecting to anyone, e.g. to audit archived downloads.
+*/
+func verifyCommand(args []string) {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "Usage: ./torrent-client verify [file path] [data path]")
+		os.Exit(1)
+	}
+	t := readTorrent(args[0])
+
+	bitfield := download.Verify(t, args[1], download.AllPieces(t), func(checked uint32, total uint32) {
+		fmt.Printf("\rChecked %d/%d pieces", checked, total)
+	})
+	fmt.Println()
+
+	downloaded := utils.NewDownloaded(getDownloadedLen(t.Info.PieceCount))
+	downloaded.Load(bitfield)
+	valid := downloaded.GetPieceCount()
+	fmt.Printf("%d/%d pieces valid, %d missing or corrupt\n", valid, t.Info.PieceCount, t.Info.PieceCount-valid)
+	if valid != t.Info.PieceCount {
+		os.Exit(1)
+	}
+}